@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/middlewarr/server/internal/store"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+// runCommand runs a maintenance command instead of the server:
+//
+//	middlewarr reencrypt  seal the service API keys with the current master key
+func runCommand(args []string) {
+	l := tools.GetLogger()
+
+	switch args[0] {
+	case "reencrypt":
+		c := store.NewConfigurationRepository()
+
+		count, err := c.ReencryptServiceKeys()
+		if err != nil {
+			l.Fatal().
+				Err(err).
+				Msg("Cannot re-encrypt the service API keys")
+		}
+
+		l.Info().
+			Int("services", count).
+			Msg("Service API keys re-encrypted")
+	default:
+		l.Fatal().
+			Str("command", args[0]).
+			Msg("Unknown command")
+	}
+}
@@ -24,6 +24,11 @@ func main() {
 	l := tools.GetLogger()
 	s := tools.GetSettings()
 
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	templates.SyncTemplates()
 	templates.LoadTemplates()
 
@@ -34,15 +39,17 @@ func main() {
 
 	r := chi.NewRouter()
 
+	corsHandler := cors.Handler(cors.Options{
+		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Api-Key", "X-Requested-With", "X-SignalR-User-Agent"},
+		ExposedHeaders:   []string{"Link"},
+		AllowCredentials: false,
+		MaxAge:           300,
+	})
+
 	r.Route("/api", func(r chi.Router) {
-		r.Use(cors.Handler(cors.Options{
-			AllowedOrigins:   []string{"https://*", "http://*"},
-			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Api-Key"},
-			ExposedHeaders:   []string{"Link"},
-			AllowCredentials: false,
-			MaxAge:           300,
-		}))
+		r.Use(corsHandler)
 
 		// Internal: /api/admin/*
 		handlers.SetupAdminRoutes(r, c)
@@ -51,18 +58,30 @@ func main() {
 		r.HandleFunc("/*", proxy.GetProxyHandle)
 	})
 
+	r.Group(func(r chi.Router) {
+		r.Use(corsHandler)
+
+		// Proxy outside of the API: /signalr/*, /MediaCover/*, /feed/*, the
+		// indexers /{id}/api and the API key in the path /k/{key}/*
+		r.HandleFunc("/*", proxy.GetProxyHandle)
+	})
+
 	graceful(s.String("host"), "80", r)
+
+	// The requests counted since the last flush would be lost.
+	proxy.FlushQuotaUsages(c)
 }
 
 func graceful(host string, port string, handler http.Handler) {
 	l := tools.GetLogger()
 
+	// No WriteTimeout: the proxied routes have their own timeouts and
+	// streamed responses (SignalR, downloads) can last much longer.
 	server := &http.Server{
-		Addr:         host + ":" + port,
-		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:        host + ":" + port,
+		Handler:     handler,
+		ReadTimeout: 15 * time.Second,
+		IdleTimeout: 60 * time.Second,
 	}
 
 	serverError := make(chan error, 1)
@@ -28,6 +28,9 @@ func newAppsRoutesV1(repository *store.ConfigurationRepository) chi.Router {
 			r.Get("/", h.getAppById)       // GET /app/{id}
 			r.Put("/", h.putAppById)       // PUT /app/{id}
 			r.Delete("/", h.deleteAppById) // DELETE /app/{id}
+
+			r.Post("/key", h.postAppKeyById)     // POST /app/{id}/key
+			r.Delete("/key", h.deleteAppKeyById) // DELETE /app/{id}/key
 		})
 	})
 
@@ -122,3 +125,29 @@ func (h appsHandlerV1) deleteAppById(w http.ResponseWriter, r *http.Request) {
 	proxy.LoadProxy(h.repository)
 	responseHandler(w, http.StatusOK, true)
 }
+
+func (h appsHandlerV1) postAppKeyById(w http.ResponseWriter, r *http.Request) {
+	id := getIDFromContext(r.Context())
+
+	apiKey, err := h.repository.CreateAppKey(id)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	proxy.LoadProxy(h.repository)
+	responseHandler(w, http.StatusOK, apiKey)
+}
+
+func (h appsHandlerV1) deleteAppKeyById(w http.ResponseWriter, r *http.Request) {
+	id := getIDFromContext(r.Context())
+
+	err := h.repository.DestroyAppKey(id)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	proxy.LoadProxy(h.repository)
+	responseHandler(w, http.StatusOK, true)
+}
@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/middlewarr/server/internal/models"
 	"github.com/middlewarr/server/internal/proxy"
 	"github.com/middlewarr/server/internal/store"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	defaultRotationGracePeriod time.Duration = 24 * time.Hour
 )
 
 type proxiesHandlerV1 struct {
@@ -28,6 +34,9 @@ func newProxiesRoutesV1(repository *store.ConfigurationRepository) chi.Router {
 			r.Get("/", h.getProxyById)       // GET /proxy/{id}
 			r.Put("/", h.putProxyById)       // PUT /proxy/{id}
 			r.Delete("/", h.deleteProxyById) // DELETE /proxy/{id}
+
+			r.Get("/quota", h.getProxyQuotaById)     // GET /proxy/{id}/quota
+			r.Post("/rotate", h.postProxyRotateById) // POST /proxy/{id}/rotate
 		})
 	})
 
@@ -122,3 +131,78 @@ func (h proxiesHandlerV1) deleteProxyById(w http.ResponseWriter, r *http.Request
 	proxy.LoadProxy(h.repository)
 	responseHandler(w, http.StatusOK, true)
 }
+
+func (h proxiesHandlerV1) getProxyQuotaById(w http.ResponseWriter, r *http.Request) {
+	id := getIDFromContext(r.Context())
+
+	p, err := h.repository.ReadProxy(id)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	quotas, err := proxy.ReadProxyQuotas(h.repository, p)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	responseHandler(w, http.StatusOK, quotas)
+}
+
+type rotateProxyKey struct {
+	GracePeriod *string `json:"grace_period"`
+}
+
+func (h proxiesHandlerV1) postProxyRotateById(w http.ResponseWriter, r *http.Request) {
+	id := getIDFromContext(r.Context())
+
+	s := tools.GetSettings()
+
+	gracePeriod := defaultRotationGracePeriod
+	if s.Exists("proxy.rotation.gracePeriod") {
+		gracePeriod = s.Duration("proxy.rotation.gracePeriod")
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	if len(body) > 0 {
+		var rotate rotateProxyKey
+
+		err = json.Unmarshal(body, &rotate)
+		if err != nil {
+			errorHandler(w, err)
+			return
+		}
+
+		if rotate.GracePeriod != nil {
+			gracePeriod, err = time.ParseDuration(*rotate.GracePeriod)
+			if err != nil {
+				errorHandler(w, err)
+				return
+			}
+		}
+	}
+
+	apiKey, err := h.repository.RotateProxyKey(id, gracePeriod)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	p, err := h.repository.ReadProxy(id)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	p.APIKey = apiKey
+
+	proxy.LoadProxy(h.repository)
+	responseHandler(w, http.StatusOK, p)
+}
@@ -23,11 +23,15 @@ func newServicesRoutesV1(repository *store.ConfigurationRepository) chi.Router {
 	r.Get("/", h.getService)   // GET /service
 	r.Post("/", h.postService) // POST /service
 
+	r.Post("/reencrypt", h.postServiceReencrypt) // POST /service/reencrypt
+
 	r.With(withID).Group(func(r chi.Router) {
 		r.Route("/{id:[0-9]+}", func(r chi.Router) {
 			r.Get("/", h.getServiceById)       // GET /service/{id}
 			r.Put("/", h.putServiceById)       // PUT /service/{id}
 			r.Delete("/", h.deleteServiceById) // DELETE /service/{id}
+
+			r.Get("/breaker", h.getServiceBreakerById) // GET /service/{id}/breaker
 		})
 	})
 
@@ -79,6 +83,8 @@ func (h servicesHandlerV1) getServiceById(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	service.Health = proxy.ReadServiceHealth(service.ID)
+
 	responseHandler(w, http.StatusOK, service)
 }
 
@@ -122,3 +128,26 @@ func (h servicesHandlerV1) deleteServiceById(w http.ResponseWriter, r *http.Requ
 	proxy.LoadProxy(h.repository)
 	responseHandler(w, http.StatusOK, true)
 }
+
+func (h servicesHandlerV1) getServiceBreakerById(w http.ResponseWriter, r *http.Request) {
+	id := getIDFromContext(r.Context())
+
+	service, err := h.repository.ReadService(id)
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	responseHandler(w, http.StatusOK, proxy.ReadCircuitBreaker(service.ID))
+}
+
+func (h servicesHandlerV1) postServiceReencrypt(w http.ResponseWriter, r *http.Request) {
+	count, err := h.repository.ReencryptServiceKeys()
+	if err != nil {
+		errorHandler(w, err)
+		return
+	}
+
+	proxy.LoadProxy(h.repository)
+	responseHandler(w, http.StatusOK, count)
+}
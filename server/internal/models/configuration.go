@@ -1,29 +1,140 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Service struct {
 	GormModel
-	Type    string  `json:"type"`
-	Name    string  `json:"name" gorm:"uniqueIndex;type:text collate nocase"`
-	URL     string  `json:"url" gorm:"uniqueIndex;type:text collate nocase"`
-	APIKey  string  `json:"api_key" gorm:"uniqueIndex;type:text collate nocase"`
-	Proxies []Proxy `json:"proxies" gorm:"foreignKey:ServiceID"`
+	Type      string                `json:"type"`
+	Name      string                `json:"name" gorm:"uniqueIndex;type:text collate nocase"`
+	URL       string                `json:"url" gorm:"uniqueIndex;type:text collate nocase"`
+	APIKey    string                `json:"api_key" gorm:"type:text collate nocase"`
+	Balancing string                `json:"balancing"`
+	Targets   []ServiceTarget       `json:"targets" gorm:"foreignKey:ServiceID"`
+	Health    []ServiceTargetHealth `json:"health,omitempty" gorm:"-"`
+	Proxies   []Proxy               `json:"proxies" gorm:"foreignKey:ServiceID"`
+}
+
+// MaskedSecret replaces the secrets in the admin API responses.
+const MaskedSecret = "********"
+
+// MarshalJSON masks the API key of the service.
+func (s Service) MarshalJSON() ([]byte, error) {
+	type service Service
+
+	masked := service(s)
+	if masked.APIKey != "" {
+		masked.APIKey = MaskedSecret
+	}
+
+	return json.Marshal(masked)
+}
+
+type ServiceTarget struct {
+	GormModel
+	ServiceID uint   `json:"service_id" gorm:"index;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	URL       string `json:"url"`
+	Weight    int    `json:"weight"`
+	Priority  int    `json:"priority"`
+}
+
+// DefaultTargetWeight is the weight of a target created without one.
+const DefaultTargetWeight = 1
+
+// UnmarshalJSON gives the default weight to a target without one, an
+// explicit 0 is kept to be refused.
+func (t *ServiceTarget) UnmarshalJSON(data []byte) error {
+	type serviceTarget ServiceTarget
+
+	target := serviceTarget{Weight: DefaultTargetWeight}
+	if err := json.Unmarshal(data, &target); err != nil {
+		return err
+	}
+
+	*t = ServiceTarget(target)
+
+	return nil
+}
+
+type ServiceTargetHealth struct {
+	URL               string     `json:"url"`
+	Healthy           bool       `json:"healthy"`
+	ActiveConnections int64      `json:"active_connections"`
+	Failures          int        `json:"failures"`
+	LastError         string     `json:"last_error,omitempty"`
+	CheckedAt         *time.Time `json:"checked_at,omitempty"`
 }
 
 type App struct {
 	GormModel
-	Template string  `json:"template"`
-	Name     string  `json:"name" gorm:"uniqueIndex;type:text collate nocase"`
-	IsActive *bool   `json:"is_active"`
-	Proxies  []Proxy `json:"proxies" gorm:"foreignKey:AppID"`
+	Template       string       `json:"template"`
+	Name           string       `json:"name" gorm:"uniqueIndex;type:text collate nocase"`
+	APIKey         *string      `json:"api_key,omitempty" gorm:"-"`
+	APIKeyHash     *string      `json:"-" gorm:"uniqueIndex"`
+	APIKeyPrefix   *string      `json:"api_key_prefix"`
+	AuthSchemes    []string     `json:"auth_schemes" gorm:"serializer:json"`
+	AllowedCIDRs   []string     `json:"allowed_cidrs" gorm:"serializer:json"`
+	DeniedCIDRs    []string     `json:"denied_cidrs" gorm:"serializer:json"`
+	IsActive       *bool        `json:"is_active"`
+	Schedule       *AppSchedule `json:"schedule" gorm:"serializer:json"`
+	ExpiresAt      *time.Time   `json:"expires_at"`
+	RateLimit      *int         `json:"rate_limit"`
+	RateLimitBurst *int         `json:"rate_limit_burst"`
+	DailyQuota     *int         `json:"daily_quota"`
+	MonthlyQuota   *int         `json:"monthly_quota"`
+	Proxies        []Proxy      `json:"proxies" gorm:"foreignKey:AppID"`
+}
+
+// AppSchedule restricts an app to time windows, `start` and `end` are "15:04"
+// times in the schedule timezone.
+type AppSchedule struct {
+	Timezone string              `json:"timezone"`
+	Windows  []AppScheduleWindow `json:"windows"`
+}
+
+type AppScheduleWindow struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
 }
 
 type Proxy struct {
 	GormModel
-	APIKey    string  `json:"api_key" gorm:"uniqueIndex;type:text collate nocase"`
-	AppID     uint    `json:"app_id" gorm:"index:idx_proxy_id,unique;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
-	App       App     `json:"app"`
-	ServiceID uint    `json:"service_id" gorm:"index:idx_proxy_id,unique;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
-	Service   Service `json:"service"`
+	APIKey          string            `json:"api_key,omitempty" gorm:"-"`
+	APIKeyHash      string            `json:"-" gorm:"uniqueIndex"`
+	APIKeyPrefix    string            `json:"api_key_prefix"`
+	AppID           uint              `json:"app_id" gorm:"index:idx_proxy_id,unique;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
+	App             App               `json:"app"`
+	ServiceID       uint              `json:"service_id" gorm:"index:idx_proxy_id,unique;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
+	Service         Service           `json:"service"`
+	RateLimit       *int              `json:"rate_limit"`
+	RateLimitBurst  *int              `json:"rate_limit_burst"`
+	DailyQuota      *int              `json:"daily_quota"`
+	MonthlyQuota    *int              `json:"monthly_quota"`
+	AllowedCIDRs    []string          `json:"allowed_cidrs" gorm:"serializer:json"`
+	DeniedCIDRs     []string          `json:"denied_cidrs" gorm:"serializer:json"`
+	APIKeyExpiresAt *time.Time        `json:"api_key_expires_at"`
+	Credentials     []ProxyCredential `json:"credentials" gorm:"foreignKey:ProxyID"`
+}
+
+// ProxyCredential is an additional API key of a proxy, such as the previous
+// key kept valid for a grace period after a rotation.
+type ProxyCredential struct {
+	GormModel
+	ProxyID      uint       `json:"proxy_id" gorm:"index;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	APIKeyHash   string     `json:"-" gorm:"uniqueIndex"`
+	APIKeyPrefix string     `json:"api_key_prefix"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+type ProxyUsage struct {
+	GormModel
+	ProxyID uint   `json:"proxy_id" gorm:"index:idx_proxy_usage_id,unique"`
+	Period  string `json:"period" gorm:"index:idx_proxy_usage_id,unique"`
+	Key     string `json:"key" gorm:"index:idx_proxy_usage_id,unique"`
+	Count   int    `json:"count"`
 }
 
 type Notification struct {
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const authTemplate = `{
+	"id": "test",
+	"name": "Test",
+	"endpoints": {
+		"sonarr": {
+			"/api/v3/series": ["GET"]
+		}
+	}
+}`
+
+func TestGetApiKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		key    string
+		scheme tools.AuthScheme
+		path   string
+	}{
+		{"none", "/api/v3/series", nil, "", "", "/api/v3/series"},
+		{"header", "/api/v3/series", http.Header{apiKeyHeaderKey: {"a"}}, "a", tools.AuthSchemeHeader, "/api/v3/series"},
+		{"bearer", "/api/v3/series", http.Header{"Authorization": {"Bearer a"}}, "a", tools.AuthSchemeBearer, "/api/v3/series"},
+		{"bearer any case", "/api/v3/series", http.Header{"Authorization": {"bearer  a "}}, "a", tools.AuthSchemeBearer, "/api/v3/series"},
+		{"basic", "/api/v3/series", http.Header{"Authorization": {"Basic dXNlcjph"}}, "a", tools.AuthSchemeBasic, "/api/v3/series"},
+		{"other authorization", "/api/v3/series", http.Header{"Authorization": {"Digest a"}}, "", "", "/api/v3/series"},
+		{"query", "/api/v3/series?apikey=a", nil, "a", tools.AuthSchemeQuery, "/api/v3/series"},
+		{"access token", "/signalr/messages?access_token=a", nil, "a", tools.AuthSchemeQuery, "/signalr/messages"},
+		{"access token outside SignalR", "/api/v3/series?access_token=a", nil, "", "", "/api/v3/series"},
+		{"path", "/k/a/api/v3/series", nil, "a", tools.AuthSchemePath, "/api/v3/series"},
+		{"path only", "/k/a", nil, "a", tools.AuthSchemePath, "/"},
+		{"header over bearer", "/api/v3/series", http.Header{apiKeyHeaderKey: {"a"}, "Authorization": {"Bearer b"}}, "a", tools.AuthSchemeHeader, "/api/v3/series"},
+		{"bearer over query", "/api/v3/series?apikey=b", http.Header{"Authorization": {"Bearer a"}}, "a", tools.AuthSchemeBearer, "/api/v3/series"},
+		{"path over header", "/k/a/api/v3/series", http.Header{apiKeyHeaderKey: {"b"}}, "a", tools.AuthSchemePath, "/api/v3/series"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+
+			key, scheme := getApiKey(r)
+			if key != tt.key || scheme != tt.scheme {
+				t.Errorf("getApiKey() = %q, %q, want %q, %q", key, scheme, tt.key, tt.scheme)
+			}
+
+			if r.URL.Path != tt.path {
+				t.Errorf("path %s, want %s", r.URL.Path, tt.path)
+			}
+		})
+	}
+}
+
+func TestGetApiKeyEscapedPath(t *testing.T) {
+	r := httptest.NewRequest("GET", "/k/a/api/v3/series/a%2Fb", nil)
+
+	if key, _ := getApiKey(r); key != "a" {
+		t.Fatalf("key %q, want a", key)
+	}
+
+	if r.URL.EscapedPath() != "/api/v3/series/a%2Fb" {
+		t.Errorf("escaped path %s, want /api/v3/series/a%%2Fb", r.URL.EscapedPath())
+	}
+}
+
+func TestAuthSchemes(t *testing.T) {
+	var authorization []string
+	tp := newTestProxy(t, authTemplate, func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		w.Write([]byte("ok"))
+	})
+
+	tp.app.AuthSchemes = []string{string(tools.AuthSchemeBearer), string(tools.AuthSchemePath)}
+	if err := tp.repository.UpdateApp(int(tp.app.ID), tp.app); err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		status int
+	}{
+		{"bearer", "/api/v3/series", http.Header{"Authorization": {"Bearer " + testClientKey}}, http.StatusOK},
+		{"path", "/k/" + testClientKey + "/api/v3/series", nil, http.StatusOK},
+		{"header disabled", "/api/v3/series", http.Header{apiKeyHeaderKey: {testClientKey}}, http.StatusUnauthorized},
+		{"query disabled", "/api/v3/series?apikey=" + testClientKey, nil, http.StatusUnauthorized},
+		{"basic disabled", "/api/v3/series", http.Header{"Authorization": {"Basic " + basicCredentials("user", testClientKey)}}, http.StatusUnauthorized},
+		{"wrong key", "/api/v3/series", http.Header{"Authorization": {"Bearer " + testServiceKey}}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve("GET", tt.target, "", tt.header); w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+
+	// The client key given as a bearer token must not reach the service.
+	for _, value := range authorization {
+		if strings.Contains(value, testClientKey) {
+			t.Errorf("Authorization %q forwarded", value)
+		}
+	}
+}
+
+func basicCredentials(user, password string) string {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth(user, password)
+
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Basic ")
+}
+
+// keyStatus sends a request authenticated with the key.
+func keyStatus(key string) int {
+	return serve("GET", "/api/v3/series", "", http.Header{apiKeyHeaderKey: {key}}).Code
+}
+
+func TestKeyExpiredAtLoad(t *testing.T) {
+	var seen []string
+	tp := newTestProxy(t, authTemplate, recordPaths(&seen))
+
+	expiresAt := time.Now().Add(-time.Minute)
+	if err := tp.repository.UpdateProxy(int(tp.proxy.ID), &models.Proxy{APIKeyExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	if _, ok := getProxyRouter().ProxyByKey[tp.proxy.APIKeyHash]; ok {
+		t.Error("expired key indexed")
+	}
+
+	if status := keyStatus(testClientKey); status != http.StatusUnauthorized || len(seen) > 0 {
+		t.Errorf("status %d, service saw %v, want %d", status, seen, http.StatusUnauthorized)
+	}
+}
+
+func TestKeyExpiresAfterLoad(t *testing.T) {
+	var seen []string
+	tp := newTestProxy(t, authTemplate, recordPaths(&seen))
+
+	expiresAt := time.Now().Add(200 * time.Millisecond)
+	if err := tp.repository.UpdateProxy(int(tp.proxy.ID), &models.Proxy{APIKeyExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	if status := keyStatus(testClientKey); status != http.StatusOK {
+		t.Fatalf("before expiry: status %d, want %d", status, http.StatusOK)
+	}
+
+	time.Sleep(time.Until(expiresAt) + 10*time.Millisecond)
+
+	if status := keyStatus(testClientKey); status != http.StatusUnauthorized || len(seen) != 1 {
+		t.Errorf("after expiry: status %d, service saw %v, want %d", status, seen, http.StatusUnauthorized)
+	}
+}
+
+func TestRotateProxyKeyGracePeriod(t *testing.T) {
+	tp := newTestProxy(t, authTemplate, recordPaths(new([]string)))
+
+	gracePeriod := 200 * time.Millisecond
+	rotatedAt := time.Now()
+
+	apiKey, err := tp.repository.RotateProxyKey(int(tp.proxy.ID), gracePeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	if status := keyStatus(testClientKey); status != http.StatusOK {
+		t.Errorf("previous key during the grace period: status %d, want %d", status, http.StatusOK)
+	}
+
+	if status := keyStatus(apiKey); status != http.StatusOK {
+		t.Errorf("new key during the grace period: status %d, want %d", status, http.StatusOK)
+	}
+
+	time.Sleep(time.Until(rotatedAt.Add(gracePeriod)) + 10*time.Millisecond)
+
+	if status := keyStatus(testClientKey); status != http.StatusUnauthorized {
+		t.Errorf("previous key after the grace period: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	if status := keyStatus(apiKey); status != http.StatusOK {
+		t.Errorf("new key after the grace period: status %d, want %d", status, http.StatusOK)
+	}
+
+	// Without a grace period the previous key is revoked at once.
+	newAPIKey, err := tp.repository.RotateProxyKey(int(tp.proxy.ID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	if status := keyStatus(apiKey); status != http.StatusUnauthorized {
+		t.Errorf("previous key without a grace period: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	if status := keyStatus(newAPIKey); status != http.StatusOK {
+		t.Errorf("new key without a grace period: status %d, want %d", status, http.StatusOK)
+	}
+}
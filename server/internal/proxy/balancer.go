@@ -0,0 +1,377 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	defaultHealthCheckInterval time.Duration = 30 * time.Second
+)
+
+type upstreamTarget struct {
+	url      *url.URL
+	weight   int
+	priority int
+	active   atomic.Int64
+
+	mu        sync.Mutex
+	healthy   bool
+	failures  int
+	lastError string
+	checkedAt time.Time
+}
+
+// balancer spreads the requests of a service over its upstream targets,
+// skipping the targets which failed until a health probe succeeds again.
+type balancer struct {
+	service  models.Service
+	strategy tools.ServiceBalancing
+	targets  []*upstreamTarget
+	counter  atomic.Uint64
+}
+
+var balancers sync.Map
+
+var onceHealthChecks sync.Once
+
+// getServiceTargets returns the service URL as the primary target followed by
+// the additional targets of the service.
+func getServiceTargets(service models.Service) []models.ServiceTarget {
+	targets := []models.ServiceTarget{{
+		URL:    service.URL,
+		Weight: models.DefaultTargetWeight,
+	}}
+
+	for _, target := range service.Targets {
+		if target.URL == service.URL {
+			continue
+		}
+
+		if target.Weight <= 0 {
+			target.Weight = models.DefaultTargetWeight
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// getBalancer builds the balancer of a service, the health state of targets
+// already known is kept across reloads.
+func getBalancer(service models.Service) (*balancer, error) {
+	previous := make(map[string]*upstreamTarget)
+
+	if v, ok := balancers.Load(service.ID); ok {
+		for _, target := range v.(*balancer).targets {
+			previous[target.url.String()] = target
+		}
+	}
+
+	strategy := tools.ServiceBalancing(service.Balancing)
+	if strategy == "" {
+		strategy = tools.PrimaryBackup
+	}
+
+	b := &balancer{
+		service:  service,
+		strategy: strategy,
+	}
+
+	for _, t := range getServiceTargets(service) {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid target URL %s", t.URL)
+		}
+
+		target, ok := previous[u.String()]
+		if ok {
+			target.mu.Lock()
+			target.weight = t.Weight
+			target.priority = t.Priority
+			target.mu.Unlock()
+		} else {
+			target = &upstreamTarget{
+				url:      u,
+				weight:   t.Weight,
+				priority: t.Priority,
+				healthy:  true,
+			}
+		}
+
+		b.targets = append(b.targets, target)
+	}
+
+	balancers.Store(service.ID, b)
+
+	onceHealthChecks.Do(func() {
+		go runHealthChecks()
+	})
+
+	return b, nil
+}
+
+func pruneBalancers(serviceIDs map[uint]bool) {
+	balancers.Range(func(key, _ any) bool {
+		if !serviceIDs[key.(uint)] {
+			balancers.Delete(key)
+		}
+
+		return true
+	})
+}
+
+func ReadServiceHealth(serviceID uint) []models.ServiceTargetHealth {
+	health := []models.ServiceTargetHealth{}
+
+	v, ok := balancers.Load(serviceID)
+	if !ok {
+		return health
+	}
+
+	for _, target := range v.(*balancer).targets {
+		health = append(health, target.health())
+	}
+
+	return health
+}
+
+func (t *upstreamTarget) health() models.ServiceTargetHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	health := models.ServiceTargetHealth{
+		URL:               t.url.String(),
+		Healthy:           t.healthy,
+		ActiveConnections: t.active.Load(),
+		Failures:          t.failures,
+		LastError:         t.lastError,
+	}
+
+	if !t.checkedAt.IsZero() {
+		checkedAt := t.checkedAt
+		health.CheckedAt = &checkedAt
+	}
+
+	return health
+}
+
+func (t *upstreamTarget) isHealthy() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.healthy
+}
+
+func (t *upstreamTarget) markSuccess() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.healthy = true
+	t.failures = 0
+	t.lastError = ""
+	t.checkedAt = time.Now()
+}
+
+func (t *upstreamTarget) markFailure(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.healthy = false
+	t.failures++
+	t.lastError = err.Error()
+	t.checkedAt = time.Now()
+}
+
+func (b *balancer) pick() *upstreamTarget {
+	var candidates []*upstreamTarget
+
+	for _, target := range b.targets {
+		if target.isHealthy() {
+			candidates = append(candidates, target)
+		}
+	}
+
+	// Every target is down, keep trying all of them.
+	if len(candidates) == 0 {
+		candidates = b.targets
+	}
+
+	switch b.strategy {
+	case tools.LeastConnections:
+		return b.pickLeastConnections(candidates)
+	case tools.RoundRobin:
+		return b.pickRoundRobin(candidates)
+	default:
+		priority := math.MaxInt
+		for _, target := range candidates {
+			priority = min(priority, target.priority)
+		}
+
+		var primaries []*upstreamTarget
+		for _, target := range candidates {
+			if target.priority == priority {
+				primaries = append(primaries, target)
+			}
+		}
+
+		return b.pickRoundRobin(primaries)
+	}
+}
+
+func (b *balancer) pickRoundRobin(candidates []*upstreamTarget) *upstreamTarget {
+	total := 0
+	for _, target := range candidates {
+		total += target.weight
+	}
+
+	n := int(b.counter.Add(1) % uint64(total))
+
+	for _, target := range candidates {
+		if n < target.weight {
+			return target
+		}
+
+		n -= target.weight
+	}
+
+	return candidates[0]
+}
+
+func (b *balancer) pickLeastConnections(candidates []*upstreamTarget) *upstreamTarget {
+	var best *upstreamTarget
+	bestLoad := math.Inf(1)
+
+	for _, target := range candidates {
+		load := float64(target.active.Load()) / float64(target.weight)
+		if load < bestLoad {
+			best = target
+			bestLoad = load
+		}
+	}
+
+	return best
+}
+
+func probeTarget(service models.Service, target *upstreamTarget) error {
+	service.URL = strings.TrimSuffix(target.url.String(), "/")
+
+	return tools.ValidateServiceHealth(service)
+}
+
+// runHealthChecks probes the unhealthy targets, healthy ones are kept up to
+// date by the proxied traffic.
+func runHealthChecks() {
+	interval := getSettingsDuration("proxy.health.interval", defaultHealthCheckInterval)
+
+	for range time.Tick(interval) {
+		balancers.Range(func(_, v any) bool {
+			b := v.(*balancer)
+
+			for _, target := range b.targets {
+				if target.isHealthy() {
+					continue
+				}
+
+				if err := probeTarget(b.service, target); err != nil {
+					target.markFailure(err)
+					continue
+				}
+
+				target.markSuccess()
+			}
+
+			return true
+		})
+	}
+}
+
+// trackedBody releases the connection slot of a target once the response is
+// fully consumed.
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+
+	return err
+}
+
+// trackedConn is the trackedBody of an upgraded connection.
+type trackedConn struct {
+	io.ReadWriteCloser
+	once sync.Once
+	done func()
+}
+
+func (c *trackedConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(c.done)
+
+	return err
+}
+
+type balancerTransport struct {
+	next     http.RoundTripper
+	balancer *balancer
+}
+
+func (t *balancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := t.balancer.pick()
+
+	outreq := req.Clone(req.Context())
+	outreq.URL.Scheme = target.url.Scheme
+	outreq.URL.Host = target.url.Host
+	outreq.URL.Path = strings.TrimSuffix(target.url.Path, "/") + req.URL.Path
+	if req.URL.RawPath != "" {
+		outreq.URL.RawPath = strings.TrimSuffix(target.url.EscapedPath(), "/") + req.URL.RawPath
+	}
+	outreq.Host = target.url.Host
+
+	target.active.Add(1)
+	done := func() {
+		target.active.Add(-1)
+	}
+
+	res, err := t.next.RoundTrip(outreq)
+	if err != nil {
+		done()
+
+		if isConnectionError(err) {
+			target.markFailure(err)
+		}
+
+		return nil, err
+	}
+
+	target.markSuccess()
+
+	if conn, ok := res.Body.(io.ReadWriteCloser); ok && res.StatusCode == http.StatusSwitchingProtocols {
+		res.Body = &trackedConn{ReadWriteCloser: conn, done: done}
+	} else if res.Body != nil {
+		res.Body = &trackedBody{ReadCloser: res.Body, done: done}
+	} else {
+		done()
+	}
+
+	return res, nil
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+func TestGetServiceTargets(t *testing.T) {
+	service := models.Service{
+		URL: "http://sonarr:8989",
+		Targets: []models.ServiceTarget{
+			{URL: "http://sonarr:8989", Weight: 5},
+			{URL: "http://sonarr-2:8989", Weight: 3, Priority: 1},
+			{URL: "http://sonarr-3:8989"},
+		},
+	}
+
+	var got []string
+	for _, target := range getServiceTargets(service) {
+		got = append(got, fmt.Sprintf("%s %d", target.URL, target.Weight))
+	}
+
+	want := []string{"http://sonarr:8989 1", "http://sonarr-2:8989 3", "http://sonarr-3:8989 1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("targets %v, want %v", got, want)
+	}
+}
+
+type testTarget struct {
+	weight   int
+	priority int
+	healthy  bool
+	active   int64
+}
+
+func TestBalancerPick(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy tools.ServiceBalancing
+		targets  []testTarget
+		picks    []int
+	}{
+		{"primary", tools.PrimaryBackup, []testTarget{{1, 0, true, 0}, {1, 1, true, 0}}, []int{4, 0}},
+		{"primaries sharing a priority", tools.PrimaryBackup, []testTarget{{1, 0, true, 0}, {1, 0, true, 0}, {1, 1, true, 0}}, []int{2, 2, 0}},
+		{"backup", tools.PrimaryBackup, []testTarget{{1, 0, false, 0}, {1, 1, true, 0}, {1, 2, true, 0}}, []int{0, 4, 0}},
+		{"every target down", tools.PrimaryBackup, []testTarget{{1, 0, false, 0}, {1, 1, false, 0}}, []int{4, 0}},
+		{"round robin", tools.RoundRobin, []testTarget{{1, 0, true, 0}, {1, 1, true, 0}}, []int{2, 2}},
+		{"weighted round robin", tools.RoundRobin, []testTarget{{3, 0, true, 0}, {1, 0, true, 0}}, []int{3, 1}},
+		{"round robin skipping a target down", tools.RoundRobin, []testTarget{{1, 0, true, 0}, {1, 0, false, 0}, {1, 0, true, 0}}, []int{2, 0, 2}},
+		{"least connections", tools.LeastConnections, []testTarget{{1, 0, true, 3}, {1, 0, true, 1}}, []int{0, 4}},
+		{"weighted least connections", tools.LeastConnections, []testTarget{{4, 0, true, 3}, {1, 0, true, 1}}, []int{4, 0}},
+		{"least connections skipping a target down", tools.LeastConnections, []testTarget{{1, 0, true, 3}, {1, 0, false, 0}}, []int{4, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &balancer{strategy: tt.strategy}
+
+			for i, target := range tt.targets {
+				u, _ := url.Parse(fmt.Sprintf("http://sonarr-%d:8989", i))
+
+				ut := &upstreamTarget{url: u, weight: target.weight, priority: target.priority, healthy: target.healthy}
+				ut.active.Store(target.active)
+
+				b.targets = append(b.targets, ut)
+			}
+
+			picks := make([]int, len(b.targets))
+			for range 4 {
+				picks[slices.Index(b.targets, b.pick())]++
+			}
+
+			if !slices.Equal(picks, tt.picks) {
+				t.Errorf("picks %v, want %v", picks, tt.picks)
+			}
+		})
+	}
+}
+
+func TestBalancerTransport(t *testing.T) {
+	var sent []string
+
+	up, _ := url.Parse("http://sonarr:8989/sonarr/")
+	down, _ := url.Parse("http://sonarr-2:8989")
+
+	b := &balancer{
+		strategy: tools.PrimaryBackup,
+		targets: []*upstreamTarget{
+			{url: down, weight: 1, priority: 0, healthy: true},
+			{url: up, weight: 1, priority: 1, healthy: true},
+		},
+	}
+
+	transport := &balancerTransport{
+		next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			sent = append(sent, r.URL.String())
+
+			if r.URL.Host == down.Host {
+				return nil, syscall.ECONNREFUSED
+			}
+
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+		}),
+		balancer: b,
+	}
+
+	if _, err := transport.RoundTrip(httptest.NewRequest("GET", "/api/v3/series", nil)); err == nil {
+		t.Fatal("connection error not reported")
+	}
+
+	if b.targets[0].isHealthy() || b.targets[0].active.Load() != 0 {
+		t.Fatal("target refusing connections still healthy")
+	}
+
+	res, err := transport.RoundTrip(httptest.NewRequest("GET", "/api/v3/series%2Fx", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.targets[1].active.Load() != 1 {
+		t.Errorf("%d active connections while the body is open, want 1", b.targets[1].active.Load())
+	}
+
+	res.Body.Close()
+	res.Body.Close()
+
+	if b.targets[1].active.Load() != 0 {
+		t.Errorf("%d active connections once the body is closed, want 0", b.targets[1].active.Load())
+	}
+
+	want := []string{"http://sonarr-2:8989/api/v3/series", "http://sonarr:8989/sonarr/api/v3/series%2Fx"}
+	if !slices.Equal(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+}
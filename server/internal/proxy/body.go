@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/middlewarr/server/internal/tools"
+)
+
+// Bodies are buffered to be validated, larger ones are rejected.
+const maxValidatedBodySize int64 = 10 << 20
+
+// bufferBody reads the body of a request and puts it back to be forwarded,
+// the error response is written when it cannot be read.
+func bufferBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValidatedBodySize))
+	r.Body.Close()
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+
+		http.Error(w, "Bad Request, cannot read body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.TransferEncoding = nil
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return body, true
+}
+
+// Validate Body
+func middlewareValidateBody() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			endpoint := getRequestInfo(r).endpoint
+			if endpoint == nil || endpoint.Route.Body == nil || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, ok := bufferBody(w, r)
+			if !ok {
+				return
+			}
+
+			if len(bytes.TrimSpace(body)) > 0 {
+				var document any
+				if err := json.Unmarshal(body, &document); err != nil {
+					http.Error(w, "Bad Request, body is not valid JSON", http.StatusBadRequest)
+					return
+				}
+
+				if violation := endpoint.Route.Body.Validate(document); violation != nil {
+					l := tools.GetLogger()
+
+					l.Warn().
+						Str("request_method", r.Method).
+						Str("request_url", tools.SanitizeURI(r)).
+						Str("proxy_app", r.Header.Get("X-Proxy-App")).
+						Str("proxy_service", r.Header.Get("X-Proxy-Service")).
+						Str("body_rule", violation.Rule).
+						Str("body_pointer", violation.Pointer).
+						Msg("Request body denied")
+
+					errorHandler(w, http.StatusForbidden, fmt.Sprintf("Forbidden, body member %s %s", violation.Pointer, violation.Rule), violation)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/middlewarr/server/internal/templates"
+)
+
+func newTestEndpoint(t *testing.T, route string) *ProxyEndpoint {
+	t.Helper()
+
+	var r templates.TemplateRoute
+	if err := json.Unmarshal([]byte(route), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	return &ProxyEndpoint{RouteEndpoint: &templates.RouteEndpoint{Method: "GET", Path: "/api/v3/series", Route: r}}
+}
+
+func TestMiddlewareValidateBody(t *testing.T) {
+	endpoint := newTestEndpoint(t, `{"methods": ["POST"], "body": {"allowed": ["/title"], "fixed": {"/tvdbId": 81189}}}`)
+
+	var forwarded []string
+	handler := middlewareValidateBody()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = append(forwarded, string(body))
+	}))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"empty", "", http.StatusOK},
+		{"allowed", `{"title": "Firefly", "tvdbId": 81189}`, http.StatusOK},
+		{"not allowed", `{"title": "Firefly", "path": "/tv"}`, http.StatusForbidden},
+		{"fixed", `{"tvdbId": 1}`, http.StatusForbidden},
+		{"not JSON", `title=Firefly`, http.StatusBadRequest},
+		{"too large", `{"title": "` + strings.Repeat("x", int(maxValidatedBodySize)) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+
+			r := httptest.NewRequest("POST", "/api/v3/series", strings.NewReader(tt.body))
+			r = withRequestInfo(r, &requestInfo{endpoint: endpoint})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if tt.status != http.StatusOK {
+				if len(forwarded) != 0 {
+					t.Error("body forwarded")
+				}
+
+				return
+			}
+
+			// The buffered body is forwarded whole.
+			if len(forwarded) != 1 || forwarded[0] != tt.body {
+				t.Errorf("forwarded body %q, want %q", forwarded, tt.body)
+			}
+		})
+	}
+}
+
+func TestMiddlewareValidateBodyWithoutRules(t *testing.T) {
+	called := false
+	handler := middlewareValidateBody()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	r := httptest.NewRequest("POST", "/api/v3/series", strings.NewReader("not JSON"))
+	r = withRequestInfo(r, &requestInfo{endpoint: newTestEndpoint(t, `["POST"]`)})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if !called || w.Code != http.StatusOK {
+		t.Errorf("status %d, forwarded %v", w.Code, called)
+	}
+}
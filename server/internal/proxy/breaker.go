@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	breakerStateClosed   string = "closed"
+	breakerStateOpen     string = "open"
+	breakerStateHalfOpen string = "half_open"
+
+	defaultBreakerFailureThreshold int           = 5
+	defaultBreakerOpenTimeout      time.Duration = 30 * time.Second
+)
+
+// Circuit breaker of a service. It opens after consecutive upstream failures
+// and fails fast until the open timeout elapses; it then turns half-open while
+// the service health probe runs, and closes again once the probe succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	service   models.Service
+	state     string
+	failures  int
+	openedAt  time.Time
+	retryAt   time.Time
+	lastError string
+}
+
+type CircuitBreakerStatus struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+var circuitBreakers sync.Map
+
+func getCircuitBreaker(service models.Service) *circuitBreaker {
+	v, _ := circuitBreakers.LoadOrStore(service.ID, &circuitBreaker{
+		service: service,
+		state:   breakerStateClosed,
+	})
+
+	cb := v.(*circuitBreaker)
+
+	cb.mu.Lock()
+	cb.service = service
+	cb.mu.Unlock()
+
+	return cb
+}
+
+func pruneCircuitBreakers(serviceIDs map[uint]bool) {
+	circuitBreakers.Range(func(key, _ any) bool {
+		if !serviceIDs[key.(uint)] {
+			circuitBreakers.Delete(key)
+		}
+
+		return true
+	})
+}
+
+func ReadCircuitBreaker(serviceID uint) CircuitBreakerStatus {
+	v, ok := circuitBreakers.Load(serviceID)
+	if !ok {
+		return CircuitBreakerStatus{State: breakerStateClosed}
+	}
+
+	return v.(*circuitBreaker).status()
+}
+
+func (cb *circuitBreaker) status() CircuitBreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := CircuitBreakerStatus{
+		State:     cb.state,
+		Failures:  cb.failures,
+		LastError: cb.lastError,
+	}
+
+	if cb.state != breakerStateClosed {
+		openedAt := cb.openedAt
+		retryAt := cb.retryAt
+
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+
+	return status
+}
+
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerStateClosed:
+		return true
+	case breakerStateOpen:
+		if time.Now().Before(cb.retryAt) {
+			return false
+		}
+
+		cb.state = breakerStateHalfOpen
+		go cb.probe(cb.service)
+	}
+
+	return false
+}
+
+func (cb *circuitBreaker) probe(service models.Service) {
+	l := tools.GetLogger()
+
+	// The service is back as soon as one of its targets answers.
+	var err error
+	for _, target := range getServiceTargets(service) {
+		service.URL = target.URL
+
+		if err = tools.ValidateServiceHealth(service); err == nil {
+			break
+		}
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err != nil {
+		cb.lastError = err.Error()
+		cb.open()
+		return
+	}
+
+	cb.state = breakerStateClosed
+	cb.failures = 0
+	cb.lastError = ""
+
+	l.Info().
+		Str("service_name", service.Name).
+		Str("service_type", service.Type).
+		Msg("Circuit breaker closed")
+}
+
+func (cb *circuitBreaker) open() {
+	l := tools.GetLogger()
+
+	now := time.Now()
+
+	cb.state = breakerStateOpen
+	cb.openedAt = now
+	cb.retryAt = now.Add(getSettingsDuration("proxy.breaker.openTimeout", defaultBreakerOpenTimeout))
+
+	l.Warn().
+		Str("service_name", cb.service.Name).
+		Str("service_type", cb.service.Type).
+		Int("failures", cb.failures).
+		Str("last_error", cb.lastError).
+		Time("retry_at", cb.retryAt).
+		Msg("Circuit breaker open")
+}
+
+func (cb *circuitBreaker) recordFailure(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.lastError = err.Error()
+
+	threshold := getSettingsInt("proxy.breaker.failureThreshold", defaultBreakerFailureThreshold)
+
+	if cb.state == breakerStateClosed && cb.failures >= threshold {
+		cb.open()
+	}
+}
+
+func (cb *circuitBreaker) recordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerStateClosed {
+		cb.failures = 0
+	}
+}
+
+// Circuit Breaker
+func middlewareCircuitBreaker(service models.Service, cb *circuitBreaker) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cb.allow() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			status := cb.status()
+
+			if status.RetryAt != nil {
+				retryAfter := math.Max(1, math.Ceil(time.Until(*status.RetryAt).Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+			}
+
+			message := fmt.Sprintf("Service Unavailable, %s is not responding", service.Name)
+			errorHandler(w, http.StatusServiceUnavailable, message, status)
+		})
+	}
+}
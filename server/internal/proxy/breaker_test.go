@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+func newTestBreaker(t *testing.T) (*circuitBreaker, *atomic.Bool, *atomic.Int32) {
+	t.Helper()
+
+	var healthy atomic.Bool
+	var probes atomic.Int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"appName":"Sonarr","version":"4.0.0"}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	service := models.Service{Type: "sonarr", Name: "sonarr", URL: upstream.URL, APIKey: testServiceKey}
+
+	return &circuitBreaker{service: service, state: breakerStateClosed}, &healthy, &probes
+}
+
+// waitBreaker waits for the probe of a half-open breaker to end.
+func waitBreaker(t *testing.T, cb *circuitBreaker) CircuitBreakerStatus {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status := cb.status(); status.State != breakerStateHalfOpen {
+			return status
+		}
+	}
+
+	t.Fatal("breaker still half-open")
+
+	return CircuitBreakerStatus{}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	cb, _, _ := newTestBreaker(t)
+	upstreamErr := errors.New("upstream responded 502 Bad Gateway")
+
+	for range defaultBreakerFailureThreshold - 1 {
+		cb.recordFailure(upstreamErr)
+	}
+
+	cb.recordSuccess()
+
+	if status := cb.status(); status.State != breakerStateClosed || status.Failures != 0 {
+		t.Fatalf("breaker %+v after a success, want closed without failures", status)
+	}
+
+	for range defaultBreakerFailureThreshold {
+		if !cb.allow() {
+			t.Fatal("closed breaker refused a request")
+		}
+
+		cb.recordFailure(upstreamErr)
+	}
+
+	status := cb.status()
+	if status.State != breakerStateOpen || status.RetryAt == nil || status.LastError != upstreamErr.Error() {
+		t.Fatalf("breaker %+v, want open", status)
+	}
+
+	if cb.allow() {
+		t.Error("open breaker allowed a request")
+	}
+
+	w := httptest.NewRecorder()
+	middlewareCircuitBreaker(cb.service, cb)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request forwarded through an open breaker")
+	})).ServeHTTP(w, httptest.NewRequest("GET", "/api/v3/series", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status %d with Retry-After %q, want 503 with a delay", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		healthy bool
+		state   string
+	}{
+		{"probe failing", false, breakerStateOpen},
+		{"probe succeeding", true, breakerStateClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, healthy, probes := newTestBreaker(t)
+			healthy.Store(tt.healthy)
+
+			cb.mu.Lock()
+			cb.failures = defaultBreakerFailureThreshold
+			cb.open()
+			cb.retryAt = time.Now().Add(-time.Second)
+			cb.mu.Unlock()
+
+			// The request past the open timeout starts the probe, it is not
+			// forwarded itself.
+			if cb.allow() {
+				t.Fatal("request allowed while the breaker turns half-open")
+			}
+
+			if cb.allow() {
+				t.Fatal("request allowed while the breaker is half-open")
+			}
+
+			status := waitBreaker(t, cb)
+
+			if status.State != tt.state {
+				t.Fatalf("breaker %s after the probe, want %s", status.State, tt.state)
+			}
+
+			if got := probes.Load(); got != 1 {
+				t.Errorf("%d probes, want 1", got)
+			}
+
+			switch tt.state {
+			case breakerStateOpen:
+				if status.RetryAt == nil || !status.RetryAt.After(time.Now()) || status.LastError == "" {
+					t.Errorf("breaker %+v, want reopened for another timeout", status)
+				}
+			case breakerStateClosed:
+				if status.Failures != 0 || status.LastError != "" || !cb.allow() {
+					t.Errorf("breaker %+v, want closed without failures", status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	cacheStatusHit   string = "hit"
+	cacheStatusMiss  string = "miss"
+	cacheStatusStale string = "stale"
+
+	defaultCacheMaxSize int64         = 64 // MB
+	cacheStaleMaxAge    time.Duration = 24 * time.Hour
+)
+
+type cacheEntry struct {
+	key          string
+	status       int
+	header       http.Header
+	body         []byte
+	storedAt     time.Time
+	expiresAt    time.Time
+	staleIfError bool
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.body))
+}
+
+// Size-bounded LRU cache of upstream responses shared by every proxy.
+type responseCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+var onceResponseCache sync.Once
+
+var cache *responseCache
+
+func getResponseCache() *responseCache {
+	onceResponseCache.Do(func() {
+		s := tools.GetSettings()
+
+		maxSize := s.Int64("cache.maxSize")
+		if maxSize <= 0 {
+			maxSize = defaultCacheMaxSize
+		}
+
+		cache = &responseCache{
+			maxSize: maxSize * 1024 * 1024,
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+	})
+
+	return cache
+}
+
+// get returns the entry stored for key and whether it is still fresh.
+func (c *responseCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	now := time.Now()
+
+	if now.Before(entry.expiresAt) {
+		c.lru.MoveToFront(el)
+		return entry, true
+	}
+
+	if entry.staleIfError && now.Before(entry.expiresAt.Add(cacheStaleMaxAge)) {
+		return entry, false
+	}
+
+	c.remove(el)
+
+	return nil, false
+}
+
+func (c *responseCache) set(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry.size() > c.maxSize/4 {
+		return
+	}
+
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size()
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+
+	c.lru.Remove(el)
+	delete(c.entries, entry.key)
+	c.size -= entry.size()
+}
+
+// getCacheKey scopes the entries by service and by its API key, the responses
+// cached before the key changes are not served anymore.
+func getCacheKey(service models.Service, key *serviceKey, rewriter *responseRewriter, r *http.Request) string {
+	query := r.URL.Query()
+	query.Del(apiKeyQueryParamKey)
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	normalizedQuery := url.Values{}
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		normalizedQuery[k] = values
+	}
+
+	encoding := "identity"
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		encoding = "gzip"
+	}
+
+	return strings.Join([]string{
+		strconv.FormatUint(uint64(service.ID), 10),
+		key.scope(),
+		strings.ToLower(r.URL.Path),
+		normalizedQuery.Encode(),
+		encoding,
+		rewriter.cacheKey(r),
+	}, "\n")
+}
+
+func serveCacheEntry(w http.ResponseWriter, entry *cacheEntry, cacheStatus string) {
+	header := w.Header()
+	for k, v := range entry.header {
+		header[k] = v
+	}
+
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.storedAt).Seconds())))
+	header.Set("X-Cache", strings.ToUpper(cacheStatus))
+
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// cacheWriter records the upstream response. When buffered, nothing is sent
+// to the client so a stale entry can still be served in place of an error.
+type cacheWriter struct {
+	http.ResponseWriter
+	buffered bool
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (cw *cacheWriter) Header() http.Header {
+	if cw.buffered {
+		return cw.header
+	}
+
+	return cw.ResponseWriter.Header()
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+
+	cw.status = status
+
+	if !cw.buffered {
+		cw.header = cw.ResponseWriter.Header().Clone()
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	// Responses too large to be cached are passed through, even when a stale
+	// entry could have been served in their place.
+	if !cw.overflow && int64(cw.body.Len()+len(b)) > cw.limit {
+		cw.overflow = true
+
+		if cw.buffered {
+			cw.buffered = false
+			cw.flushBuffer()
+		}
+
+		cw.body.Reset()
+	}
+
+	if cw.overflow {
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.body.Write(b)
+
+	if cw.buffered {
+		return len(b), nil
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	if cw.buffered {
+		return
+	}
+
+	if fl, ok := cw.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *cacheWriter) flushBuffer() {
+	header := cw.ResponseWriter.Header()
+	for k, v := range cw.header {
+		header[k] = v
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.ResponseWriter.Write(cw.body.Bytes())
+}
+
+// setImageCacheControl lets the clients keep the images of a cached route, the
+// MediaCover posters, as long as middlewarr does.
+func setImageCacheControl(res *http.Response, endpoint *ProxyEndpoint) {
+	if endpoint == nil || endpoint.Route.Cache == nil || res.StatusCode != http.StatusOK {
+		return
+	}
+
+	ttl := time.Duration(endpoint.Route.Cache.TTL)
+	if ttl <= 0 || res.Header.Get("Cache-Control") != "" {
+		return
+	}
+
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "image/") {
+		res.Header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(ttl.Seconds())))
+	}
+}
+
+// Cache
+func middlewareCache(service models.Service, key *serviceKey, rewriter *responseRewriter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := getRequestInfo(r)
+
+			if r.Method != http.MethodGet || info.endpoint == nil || info.endpoint.Route.Cache == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			options := info.endpoint.Route.Cache
+			ttl := time.Duration(options.TTL)
+
+			if ttl <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			c := getResponseCache()
+			cacheKey := getCacheKey(service, key, rewriter, r)
+
+			entry, fresh := c.get(cacheKey)
+			if fresh {
+				info.cache = cacheStatusHit
+				serveCacheEntry(w, entry, info.cache)
+				return
+			}
+
+			info.cache = cacheStatusMiss
+
+			cw := &cacheWriter{
+				ResponseWriter: w,
+				buffered:       entry != nil,
+				header:         make(http.Header),
+				limit:          c.maxSize / 4,
+			}
+
+			next.ServeHTTP(cw, r)
+
+			if cw.status == 0 {
+				cw.status = http.StatusOK
+			}
+
+			if cw.buffered {
+				if cw.status >= http.StatusInternalServerError {
+					info.cache = cacheStatusStale
+					serveCacheEntry(w, entry, info.cache)
+					return
+				}
+
+				cw.flushBuffer()
+			}
+
+			if cw.status != http.StatusOK || cw.overflow {
+				return
+			}
+
+			header := cw.header.Clone()
+			header.Del("Set-Cookie")
+			header.Del("X-Cache")
+			header.Del("X-RateLimit-Limit")
+			header.Del("X-RateLimit-Remaining")
+			header.Del("X-RateLimit-Reset")
+
+			now := time.Now()
+
+			c.set(&cacheEntry{
+				key:          cacheKey,
+				status:       cw.status,
+				header:       header,
+				body:         bytes.Clone(cw.body.Bytes()),
+				storedAt:     now,
+				expiresAt:    now.Add(ttl),
+				staleIfError: options.StaleIfError,
+			})
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+func TestCacheWriterLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		buffered bool
+		writes   []string
+		sent     string
+		cached   string
+		overflow bool
+	}{
+		{"passthrough", false, []string{"abc", "def"}, "abcdef", "abcdef", false},
+		{"passthrough over the limit", false, []string{"abcdef", "ghijkl"}, "abcdefghijkl", "", true},
+		{"buffered", true, []string{"abc", "def"}, "", "abcdef", false},
+		{"buffered over the limit", true, []string{"abcdef", "ghijkl", "mn"}, "abcdefghijklmn", "", true},
+		{"single write over the limit", true, []string{"abcdefghijkl"}, "abcdefghijkl", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			cw := &cacheWriter{ResponseWriter: w, buffered: tt.buffered, header: make(http.Header), limit: 10}
+
+			cw.Header().Set("Content-Type", "text/plain")
+
+			for _, b := range tt.writes {
+				if n, err := cw.Write([]byte(b)); n != len(b) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", b, n, err)
+				}
+			}
+
+			if got := w.Body.String(); got != tt.sent {
+				t.Errorf("sent %q, want %q", got, tt.sent)
+			}
+
+			if got := cw.body.String(); got != tt.cached {
+				t.Errorf("recorded %q, want %q", got, tt.cached)
+			}
+
+			if cw.overflow != tt.overflow {
+				t.Errorf("overflow %v, want %v", cw.overflow, tt.overflow)
+			}
+
+			if tt.sent != "" && w.Header().Get("Content-Type") != "text/plain" {
+				t.Error("headers of a passed through response lost")
+			}
+		})
+	}
+}
+
+func TestCacheKeyScope(t *testing.T) {
+	encrypted, err := tools.EncryptSecret(testServiceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reencrypted, err := tools.EncryptSecret(testServiceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getKey := func(service models.Service, target string) string {
+		key, err := newServiceKey(service)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rw := &responseRewriter{scrubber: newSecretScrubber(service.Type, key, nil)}
+		r := withRequestInfo(httptest.NewRequest("GET", target, nil), &requestInfo{apiKey: testClientKey})
+
+		return getCacheKey(service, key, rw, r)
+	}
+
+	service := models.Service{GormModel: models.GormModel{ID: 1}, Type: "sonarr", APIKey: encrypted}
+	base := getKey(service, "/api/v3/series?b=2&a=1&apikey="+testClientKey)
+
+	tests := []struct {
+		name    string
+		service models.Service
+		target  string
+		same    bool
+	}{
+		{"query order and client key", service, "/api/v3/series?a=1&b=2", true},
+		{"path case", service, "/API/v3/Series?a=1&b=2", true},
+		{"key encrypted again", models.Service{GormModel: models.GormModel{ID: 1}, Type: "sonarr", APIKey: reencrypted}, "/api/v3/series?a=1&b=2", true},
+		{"plaintext key", models.Service{GormModel: models.GormModel{ID: 1}, Type: "sonarr", APIKey: testServiceKey}, "/api/v3/series?a=1&b=2", true},
+		{"other service", models.Service{GormModel: models.GormModel{ID: 2}, Type: "sonarr", APIKey: encrypted}, "/api/v3/series?a=1&b=2", false},
+		{"other key", models.Service{GormModel: models.GormModel{ID: 1}, Type: "sonarr", APIKey: testClientKey}, "/api/v3/series?a=1&b=2", false},
+		{"other query", service, "/api/v3/series?a=1&b=3", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getKey(tt.service, tt.target); (got == base) != tt.same {
+				t.Errorf("same cache key %v, want %v", got == base, tt.same)
+			}
+		})
+	}
+
+	if strings.Contains(base, encrypted) || strings.Contains(base, testServiceKey) {
+		t.Error("the cache key holds the service API key")
+	}
+}
+
+func TestSetImageCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		route        string
+		status       int
+		contentType  string
+		cacheControl string
+		want         string
+	}{
+		{"image", `{"methods":["GET"],"cache":{"ttl":"1h"}}`, http.StatusOK, "image/jpeg", "", "private, max-age=3600"},
+		{"image with parameters", `{"methods":["GET"],"cache":{"ttl":"90s"}}`, http.StatusOK, "image/png; charset=binary", "", "private, max-age=90"},
+		{"set by the service", `{"methods":["GET"],"cache":{"ttl":"1h"}}`, http.StatusOK, "image/jpeg", "no-store", "no-store"},
+		{"not an image", `{"methods":["GET"],"cache":{"ttl":"1h"}}`, http.StatusOK, "application/json", "", ""},
+		{"not found", `{"methods":["GET"],"cache":{"ttl":"1h"}}`, http.StatusNotFound, "image/jpeg", "", ""},
+		{"not cached", `["GET"]`, http.StatusOK, "image/jpeg", "", ""},
+		{"no TTL", `{"methods":["GET"],"cache":{"ttl":"0s"}}`, http.StatusOK, "image/jpeg", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := newTestEndpoint(t, tt.route)
+
+			res := newTestResponse(t, endpoint, tt.contentType, "")
+			res.StatusCode = tt.status
+			if tt.cacheControl != "" {
+				res.Header.Set("Cache-Control", tt.cacheControl)
+			}
+
+			setImageCacheControl(res, endpoint)
+
+			if got := res.Header.Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Requests outside of the routes are left alone.
+	res := newTestResponse(t, nil, "image/jpeg", "")
+	setImageCacheControl(res, nil)
+
+	if got := res.Header.Get("Cache-Control"); got != "" {
+		t.Errorf("Cache-Control %q without an endpoint", got)
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/middlewarr/server/internal/tools"
+)
+
+// The *arr services run every task through a single command endpoint.
+var commandPathRegex = regexp.MustCompile(`(?i)^/api/v\d+/command/?$`)
+
+func isCommandRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && commandPathRegex.MatchString(r.URL.Path)
+}
+
+// newCommandAllowlist indexes the allowed command names in lower case, a nil
+// allowlist allows every command.
+func newCommandAllowlist(commands []string) map[string]bool {
+	allowlist := make(map[string]bool)
+	for _, c := range commands {
+		allowlist[strings.ToLower(c)] = true
+	}
+
+	return allowlist
+}
+
+// getCommandName returns the name of a command. The member is matched
+// regardless of its case, as the services do, so a body naming several
+// commands is refused rather than guessing which one the service would run.
+func getCommandName(body []byte) (string, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", nil
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return "", errors.New("body is not valid JSON")
+	}
+
+	var name string
+	found := false
+
+	for key, value := range members {
+		if !strings.EqualFold(key, "name") {
+			continue
+		}
+
+		if found {
+			return "", errors.New("ambiguous command name")
+		}
+
+		if err := json.Unmarshal(value, &name); err != nil {
+			return "", errors.New("invalid command name")
+		}
+
+		found = true
+	}
+
+	return name, nil
+}
+
+// Command
+func middlewareCommand(allowlist map[string]bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isCommandRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, ok := bufferBody(w, r)
+			if !ok {
+				return
+			}
+
+			name, err := getCommandName(body)
+			if err != nil && allowlist != nil {
+				http.Error(w, "Bad Request, "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			info := getRequestInfo(r)
+			info.command = name
+
+			if allowlist != nil && name == "" {
+				http.Error(w, "Bad Request, missing command name", http.StatusBadRequest)
+				return
+			}
+
+			if allowlist != nil && !allowlist[strings.ToLower(name)] {
+				l := tools.GetLogger()
+
+				l.Warn().
+					Str("request_method", r.Method).
+					Str("request_url", tools.SanitizeURI(r)).
+					Str("proxy_app", r.Header.Get("X-Proxy-App")).
+					Str("proxy_service", r.Header.Get("X-Proxy-Service")).
+					Str("command", name).
+					Msg("Command not allowed")
+
+				errorHandler(w, http.StatusForbidden, "Forbidden, command "+name+" not allowed", nil)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
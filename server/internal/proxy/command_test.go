@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsCommandRequest(t *testing.T) {
+	tests := []struct {
+		method  string
+		path    string
+		command bool
+	}{
+		{"POST", "/api/v3/command", true},
+		{"POST", "/api/v1/command/", true},
+		{"POST", "/API/V3/Command", true},
+		{"GET", "/api/v3/command", false},
+		{"POST", "/api/v3/command/42", false},
+		{"POST", "/api/v3/commands", false},
+		{"POST", "/api/command", false},
+	}
+
+	for _, tt := range tests {
+		if command := isCommandRequest(httptest.NewRequest(tt.method, tt.path, nil)); command != tt.command {
+			t.Errorf("isCommandRequest(%s %s) = %v, want %v", tt.method, tt.path, command, tt.command)
+		}
+	}
+}
+
+func TestGetCommandName(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		command string
+		valid   bool
+	}{
+		{"empty", "", "", true},
+		{"name", `{"name": "RefreshSeries", "seriesId": 1}`, "RefreshSeries", true},
+		{"any case", `{"Name": "RefreshSeries"}`, "RefreshSeries", true},
+		{"missing", `{"seriesId": 1}`, "", true},
+		{"ambiguous", `{"name": "RefreshSeries", "NAME": "DeleteSeries"}`, "", false},
+		{"not a string", `{"name": 1}`, "", false},
+		{"not an object", `["RefreshSeries"]`, "", false},
+		{"not JSON", `name=RefreshSeries`, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, err := getCommandName([]byte(tt.body))
+			if (err == nil) != tt.valid {
+				t.Fatalf("getCommandName() = %v, want valid %v", err, tt.valid)
+			}
+
+			if command != tt.command {
+				t.Errorf("getCommandName() = %q, want %q", command, tt.command)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist map[string]bool
+		body      string
+		status    int
+		command   string
+	}{
+		{"allowed", newCommandAllowlist([]string{"RefreshSeries"}), `{"name": "RefreshSeries", "seriesId": 1}`, http.StatusOK, "RefreshSeries"},
+		{"allowed any case", newCommandAllowlist([]string{"RefreshSeries"}), `{"Name": "refreshseries"}`, http.StatusOK, "refreshseries"},
+		{"not allowed", newCommandAllowlist([]string{"RefreshSeries"}), `{"name": "Backup"}`, http.StatusForbidden, "Backup"},
+		{"empty allowlist", newCommandAllowlist(nil), `{"name": "RefreshSeries"}`, http.StatusForbidden, "RefreshSeries"},
+		{"missing name", newCommandAllowlist([]string{"RefreshSeries"}), `{}`, http.StatusBadRequest, ""},
+		{"empty", newCommandAllowlist([]string{"RefreshSeries"}), "", http.StatusBadRequest, ""},
+		{"not JSON", newCommandAllowlist([]string{"RefreshSeries"}), `name=Backup`, http.StatusBadRequest, ""},
+		{"ambiguous", newCommandAllowlist([]string{"RefreshSeries"}), `{"name": "RefreshSeries", "NAME": "Backup"}`, http.StatusBadRequest, ""},
+		{"any command", nil, `{"name": "Backup"}`, http.StatusOK, "Backup"},
+		{"any body", nil, `name=Backup`, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded := false
+			handler := middlewareCommand(tt.allowlist)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+			}))
+
+			info := &requestInfo{}
+			r := withRequestInfo(httptest.NewRequest("POST", "/api/v3/command", strings.NewReader(tt.body)), info)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if forwarded != (tt.status == http.StatusOK) {
+				t.Errorf("forwarded %v", forwarded)
+			}
+
+			if info.command != tt.command {
+				t.Errorf("command %q logged, want %q", info.command, tt.command)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCommandOtherRequests(t *testing.T) {
+	forwarded := false
+	handler := middlewareCommand(newCommandAllowlist(nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v3/series", strings.NewReader(`{"name": "Backup"}`)))
+
+	if !forwarded || w.Code != http.StatusOK {
+		t.Errorf("status %d, forwarded %v", w.Code, forwarded)
+	}
+}
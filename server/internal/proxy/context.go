@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/middlewarr/server/internal/tools"
+)
+
+type contextKey string
+
+const (
+	requestInfoContextKey contextKey = "request_info"
+)
+
+// requestInfo is shared by the middlewares of a proxied request, it is filled
+// along the chain and read back when the access log line is written.
+type requestInfo struct {
+	authScheme    tools.AuthScheme
+	apiKey        string
+	servicePrefix string
+	endpoint      *ProxyEndpoint
+	cache         string
+	command       string
+	signalR       *signalRFilter
+}
+
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+
+	return r.WithContext(ctx)
+}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		return info
+	}
+
+	return &requestInfo{}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+type cidrRules struct {
+	allowed []netip.Prefix
+	denied  []netip.Prefix
+}
+
+// allow reports whether an address is not denied and, when an allowlist is
+// set, part of it.
+func (cr cidrRules) allow(addr netip.Addr) bool {
+	for _, prefix := range cr.denied {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	if len(cr.allowed) == 0 {
+		return true
+	}
+
+	for _, prefix := range cr.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newCIDRRules(allowed []string, denied []string) (cidrRules, error) {
+	allowedPrefixes, err := tools.ParseCIDRs(allowed)
+	if err != nil {
+		return cidrRules{}, err
+	}
+
+	deniedPrefixes, err := tools.ParseCIDRs(denied)
+	if err != nil {
+		return cidrRules{}, err
+	}
+
+	return cidrRules{allowedPrefixes, deniedPrefixes}, nil
+}
+
+// getIPRules returns the app rules followed by the proxy ones, a client must
+// pass both.
+func getIPRules(proxy models.Proxy, app models.App) ([]cidrRules, error) {
+	var rules []cidrRules
+
+	for _, lists := range [][2][]string{
+		{app.AllowedCIDRs, app.DeniedCIDRs},
+		{proxy.AllowedCIDRs, proxy.DeniedCIDRs},
+	} {
+		if len(lists[0]) == 0 && len(lists[1]) == 0 {
+			continue
+		}
+
+		r, err := newCIDRRules(lists[0], lists[1])
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func getTrustedProxies() []netip.Prefix {
+	l := tools.GetLogger()
+	s := tools.GetSettings()
+
+	trustedProxies, err := tools.ParseCIDRs(s.Strings("proxy.trustedProxies"))
+	if err != nil {
+		l.Error().
+			Err(err).
+			Msg("Invalid trusted proxies, forwarded headers will be ignored")
+
+		return nil
+	}
+
+	return trustedProxies
+}
+
+func isTrusted(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+// getClientAddr returns the address of the client, the X-Forwarded-For chain
+// is walked back from the connection as long as the hops are trusted.
+func getClientAddr(r *http.Request, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	addr, ok := parseAddr(r.RemoteAddr)
+	if !ok || !isTrusted(addr, trustedProxies) {
+		return addr, ok
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	if len(hops) == 0 {
+		if realIP, ok := parseAddr(r.Header.Get("X-Real-IP")); ok {
+			return realIP, true
+		}
+
+		return addr, true
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseAddr(hops[i])
+		if !ok {
+			return addr, true
+		}
+
+		addr = hop
+
+		if !isTrusted(addr, trustedProxies) {
+			break
+		}
+	}
+
+	return addr, true
+}
+
+// IP Filter
+func middlewareIPFilter(rules []cidrRules, trustedProxies []netip.Prefix) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(rules) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			addr, ok := getClientAddr(r, trustedProxies)
+
+			allowed := ok
+			for _, rule := range rules {
+				allowed = allowed && rule.allow(addr)
+			}
+
+			if !allowed {
+				l := tools.GetLogger()
+
+				l.Warn().
+					Str("request_client", addr.String()).
+					Str("request_remote_addr", r.RemoteAddr).
+					Str("request_method", r.Method).
+					Str("request_url", tools.SanitizeURI(r)).
+					Str("proxy_app", r.Header.Get("X-Proxy-App")).
+					Str("proxy_service", r.Header.Get("X-Proxy-Service")).
+					Msg("Client address denied")
+
+				http.Error(w, "Forbidden, client address not allowed", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+const ipFilterTemplate = `{
+	"id": "test",
+	"name": "Test",
+	"endpoints": {
+		"sonarr": {
+			"/api/v3/series": ["GET"]
+		}
+	}
+}`
+
+func TestCIDRRulesAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		addr    string
+		allow   bool
+	}{
+		{"no rules", nil, nil, "203.0.113.1", true},
+		{"allowed", []string{"10.0.0.0/8"}, nil, "10.1.2.3", true},
+		{"not allowed", []string{"10.0.0.0/8"}, nil, "203.0.113.1", false},
+		{"denied", nil, []string{"203.0.113.0/24"}, "203.0.113.1", false},
+		{"not denied", nil, []string{"203.0.113.0/24"}, "198.51.100.1", true},
+		{"denied within allowed", []string{"10.0.0.0/8"}, []string{"10.0.0.1"}, "10.0.0.1", false},
+		{"ipv6", []string{"2001:db8::/32"}, nil, "2001:db8::1", true},
+		{"ipv4 against ipv6", []string{"2001:db8::/32"}, nil, "10.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := newCIDRRules(tt.allowed, tt.denied)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if allow := rules.allow(netip.MustParseAddr(tt.addr)); allow != tt.allow {
+				t.Errorf("allow(%s) = %v, want %v", tt.addr, allow, tt.allow)
+			}
+		})
+	}
+}
+
+func TestGetIPRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		proxy models.Proxy
+		app   models.App
+		rules int
+		valid bool
+	}{
+		{"none", models.Proxy{}, models.App{}, 0, true},
+		{"app", models.Proxy{}, models.App{AllowedCIDRs: []string{"10.0.0.0/8"}}, 1, true},
+		{"proxy", models.Proxy{DeniedCIDRs: []string{"10.0.0.1"}}, models.App{}, 1, true},
+		{"both", models.Proxy{DeniedCIDRs: []string{"10.0.0.1"}}, models.App{AllowedCIDRs: []string{"10.0.0.0/8"}}, 2, true},
+		{"invalid", models.Proxy{AllowedCIDRs: []string{"10.0.0.0/64"}}, models.App{}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := getIPRules(tt.proxy, tt.app)
+			if (err == nil) != tt.valid {
+				t.Fatalf("getIPRules() = %v, want valid %v", err, tt.valid)
+			}
+
+			if len(rules) != tt.rules {
+				t.Errorf("%d rules, want %d", len(rules), tt.rules)
+			}
+		})
+	}
+}
+
+func TestGetClientAddr(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		addr       string
+		ok         bool
+	}{
+		{"direct", "203.0.113.1:1234", nil, "203.0.113.1", true},
+		{"untrusted forwarder", "203.0.113.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.1", true},
+		{"trusted forwarder", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1", true},
+		{"trusted without header", "192.0.2.1:1234", nil, "192.0.2.1", true},
+		{"real ip", "192.0.2.1:1234", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1", true},
+		{"forwarded over real ip", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}}, "198.51.100.1", true},
+		{"spoofed hop", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"127.0.0.1, 198.51.100.1"}}, "198.51.100.1", true},
+		{"trusted chain", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.1"}}, "198.51.100.1", true},
+		{"repeated headers", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1", "10.0.0.1"}}, "198.51.100.1", true},
+		{"fully trusted chain", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.2, 10.0.0.1"}}, "10.0.0.2", true},
+		{"invalid hop", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, unknown"}}, "192.0.2.1", true},
+		{"hop with port", "192.0.2.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1:5678"}}, "198.51.100.1", true},
+		{"mapped address", "[::ffff:203.0.113.1]:1234", nil, "203.0.113.1", true},
+		{"ipv6", "[2001:db8::1]:1234", nil, "2001:db8::1", true},
+		{"invalid remote address", "unknown", nil, "invalid IP", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v3/series", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+
+			addr, ok := getClientAddr(r, trusted)
+			if addr.String() != tt.addr || ok != tt.ok {
+				t.Errorf("getClientAddr() = %s, %v, want %s, %v", addr, ok, tt.addr, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMiddlewareIPFilter(t *testing.T) {
+	tp := newTestProxy(t, ipFilterTemplate, recordPaths(new([]string)))
+
+	tp.app.AllowedCIDRs = []string{"198.51.100.0/24"}
+	if err := tp.repository.UpdateApp(int(tp.app.ID), tp.app); err != nil {
+		t.Fatal(err)
+	}
+
+	tp.proxy.DeniedCIDRs = []string{"198.51.100.66"}
+	if err := tp.repository.UpdateProxy(int(tp.proxy.ID), tp.proxy); err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	// The requests come from 192.0.2.1, trusted by the test settings.
+	tests := []struct {
+		name      string
+		forwarded string
+		status    int
+	}{
+		{"allowed", "198.51.100.1", http.StatusOK},
+		{"not allowed by the app", "203.0.113.1", http.StatusForbidden},
+		{"denied by the proxy", "198.51.100.66", http.StatusForbidden},
+		{"trusted proxy itself", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{apiKeyHeaderKey: {testClientKey}}
+			if tt.forwarded != "" {
+				header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if w := serve("GET", "/api/v3/series", "", header); w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/middlewarr/server/internal/templates"
 	"github.com/middlewarr/server/internal/tools"
 	"github.com/rs/zerolog/hlog"
 )
@@ -29,8 +30,10 @@ func middlewareLogRequest() Middleware {
 
 			h := hlog.NewHandler(*l)
 
+			info := getRequestInfo(r)
+
 			accessHandler := hlog.AccessHandler(func(req *http.Request, status, size int, duration time.Duration) {
-				hlog.FromRequest(req).Info().
+				e := hlog.FromRequest(req).Info().
 					Str("method", req.Method).
 					Str("url", tools.SanitizeURI(req)).
 					Int("status_code", status).
@@ -38,39 +41,61 @@ func middlewareLogRequest() Middleware {
 					Dur("elapsed_ms", duration).
 					Str("proxy_app", r.Header.Get("X-Proxy-App")).
 					Str("proxy_service", r.Header.Get("X-Proxy-Service")).
-					Msg("Request")
+					Str("auth_scheme", string(info.authScheme))
+
+				if info.cache != "" {
+					e = e.Str("cache", info.cache)
+				}
+
+				if info.endpoint != nil {
+					e = e.Str("route", info.endpoint.Method+" "+info.endpoint.Path)
+				}
+
+				if info.command != "" {
+					e = e.Str("command", info.command)
+				}
+
+				e.Msg("Request")
 			})
 
 			userAgentHandler := hlog.UserAgentHandler("user_agent")
 
-			h(accessHandler(userAgentHandler(next))).ServeHTTP(w, r)
+			h(accessHandler(userAgentHandler(next))).ServeHTTP(w, withRequestInfo(r, info))
 		})
 	}
 }
 
 // Validate Requert
-func validateRequest(r *http.Request, endpoints ProxyEndpoints) bool {
-	method := r.Method
-	path := r.URL.Path
-
-	for _, endpoint := range endpoints {
-		if method == endpoint.Method && endpoint.PathRegex.MatchString(path) {
-			return true
-		}
+func validateRequest(r *http.Request, routes *templates.RouteTrie, operations map[*templates.RouteEndpoint]*tools.OpenAPIOperation) *ProxyEndpoint {
+	match := routes.Match(r.Method, r.URL.Path)
+	if match == nil {
+		return nil
 	}
 
-	return false
+	return &ProxyEndpoint{
+		RouteEndpoint: match.Endpoint,
+		Params:        match.Params,
+		Operation:     operations[match.Endpoint],
+	}
 }
 
-func middlewareValidateRequest(endpoints ProxyEndpoints) Middleware {
+func middlewareValidateRequest(routes *templates.RouteTrie, operations map[*templates.RouteEndpoint]*tools.OpenAPIOperation) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ok := validateRequest(r, endpoints)
-			if !ok {
+			// SignalR hubs are granted by middlewareSignalR.
+			if isSignalRPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			endpoint := validateRequest(r, routes, operations)
+			if endpoint == nil {
 				http.Error(w, fmt.Sprintf("Forbidden, %s %s not allowed", r.Method, r.URL.Path), http.StatusUnauthorized)
 				return
 			}
 
+			getRequestInfo(r).endpoint = endpoint
+
 			next.ServeHTTP(w, r)
 		})
 	}
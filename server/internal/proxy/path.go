@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	errEncodedSeparator = errors.New("encoded path separator")
+	errBackslash        = errors.New("backslash in path")
+	errControlChar      = errors.New("control character in path")
+	errPathTraversal    = errors.New("path above the root")
+)
+
+// The services honour these headers, a request could be matched as a GET and
+// run as a DELETE.
+var methodOverrideHeaders = []string{
+	"X-HTTP-Method-Override",
+	"X-HTTP-Method",
+	"X-Method-Override",
+}
+
+// getMethodOverride returns the method override header of a request, if any.
+func getMethodOverride(r *http.Request) string {
+	for _, header := range methodOverrideHeaders {
+		if _, ok := r.Header[http.CanonicalHeaderKey(header)]; ok {
+			return header
+		}
+	}
+
+	return ""
+}
+
+// canonicalizePath rewrites the request path into the single form matched
+// against the endpoints and forwarded to the service: empty and dot segments
+// are resolved and the trailing slash is dropped. Paths the service could
+// read differently, with encoded separators or backslashes, are rejected.
+func canonicalizePath(r *http.Request) error {
+	escaped := strings.ToLower(r.URL.EscapedPath())
+	if strings.Contains(escaped, "%2f") || strings.Contains(escaped, "%5c") {
+		return errEncodedSeparator
+	}
+
+	if strings.Contains(r.URL.Path, `\`) {
+		return errBackslash
+	}
+
+	if strings.ContainsFunc(r.URL.Path, func(c rune) bool { return c < 0x20 || c == 0x7f }) {
+		return errControlChar
+	}
+
+	var segments []string
+
+	for segment := range strings.SplitSeq(r.URL.Path, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) == 0 {
+				return errPathTraversal
+			}
+
+			segments = segments[:len(segments)-1]
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	r.URL.Path = "/" + strings.Join(segments, "/")
+	// The escaped form is computed again from the canonical path.
+	r.URL.RawPath = ""
+
+	return nil
+}
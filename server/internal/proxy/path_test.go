@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const pathTemplate = `{
+	"id": "test",
+	"name": "Test",
+	"endpoints": {
+		"sonarr": {
+			"/api/v3/series": ["GET"],
+			"/api/v3/series/{id}": ["GET"]
+		}
+	}
+}`
+
+func TestCanonicalPathCorpus(t *testing.T) {
+	var seen []string
+	newTestProxy(t, pathTemplate, recordPaths(&seen))
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		header   http.Header
+		status   int
+		upstream string
+	}{
+		{"plain", "GET", "/api/v3/series", nil, http.StatusOK, "GET /api/v3/series"},
+		{"double slash", "GET", "//api/v3/series", nil, http.StatusOK, "GET /api/v3/series"},
+		{"inner double slash", "GET", "/api//v3///series", nil, http.StatusOK, "GET /api/v3/series"},
+		{"trailing slash", "GET", "/api/v3/series/", nil, http.StatusOK, "GET /api/v3/series"},
+		{"dot segment", "GET", "/api/v3/./series", nil, http.StatusOK, "GET /api/v3/series"},
+		{"dot dot back to an allowed path", "GET", "/api/v3/series/../series/1", nil, http.StatusOK, "GET /api/v3/series/1"},
+		{"encoded dot dot", "GET", "/api/v3/%2e%2e/v3/series", nil, http.StatusOK, "GET /api/v3/series"},
+		{"dot dot to a denied path", "GET", "/api/v3/./series/../system/backup", nil, http.StatusUnauthorized, ""},
+		{"dot dot above the root", "GET", "/../api/v3/series", nil, http.StatusBadRequest, ""},
+		{"dot dot above the root after a segment", "GET", "/api/../../api/v3/series", nil, http.StatusBadRequest, ""},
+		{"encoded slash", "GET", "/api/v3/series%2F..%2Fsystem/backup", nil, http.StatusBadRequest, ""},
+		{"encoded slash lowercase", "GET", "/api/v3/series%2f1", nil, http.StatusBadRequest, ""},
+		{"encoded backslash", "GET", "/api/v3/series%5C1", nil, http.StatusBadRequest, ""},
+		{"encoded backslash lowercase", "GET", "/api/v3/series%5c..%5csystem", nil, http.StatusBadRequest, ""},
+		{"raw backslash", "GET", `/api/v3/series\..\system\backup`, nil, http.StatusBadRequest, ""},
+		{"null byte", "GET", "/api/v3/series/%00", nil, http.StatusBadRequest, ""},
+		{"newline", "GET", "/api/v3/series/1%0a", nil, http.StatusBadRequest, ""},
+		{"delete", "GET", "/api/v3/series/%7f", nil, http.StatusBadRequest, ""},
+		{"method not allowed", "DELETE", "/api/v3/series/1", nil, http.StatusUnauthorized, ""},
+		{"X-HTTP-Method-Override", "GET", "/api/v3/series/1", http.Header{"X-Http-Method-Override": {"DELETE"}}, http.StatusBadRequest, ""},
+		{"X-HTTP-Method", "GET", "/api/v3/series/1", http.Header{"X-Http-Method": {"DELETE"}}, http.StatusBadRequest, ""},
+		{"X-Method-Override", "GET", "/api/v3/series/1", http.Header{"X-Method-Override": {"DELETE"}}, http.StatusBadRequest, ""},
+		{"empty override", "GET", "/api/v3/series/1", http.Header{"X-Http-Method-Override": {""}}, http.StatusBadRequest, ""},
+		{"admin path", "GET", "/api/admin/v1/apps", nil, http.StatusNotFound, ""},
+		{"admin path behind a double slash", "GET", "/api//admin/v1/apps", nil, http.StatusNotFound, ""},
+		{"admin path behind dot segments", "GET", "/api/v3/../admin/v1/apps", nil, http.StatusNotFound, ""},
+		{"admin path in another case", "GET", "/API/Admin/v1/apps", nil, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+
+			header := http.Header{apiKeyHeaderKey: {testClientKey}}
+			for k, v := range tt.header {
+				header[k] = v
+			}
+
+			w := serve(tt.method, tt.target, "", header)
+
+			if w.Code != tt.status {
+				t.Fatalf("%s %s: status %d, want %d (%s)", tt.method, tt.target, w.Code, tt.status, w.Body.String())
+			}
+
+			switch {
+			case tt.upstream == "" && len(seen) > 0:
+				t.Errorf("%s %s: denied request reached the service as %v", tt.method, tt.target, seen)
+			case tt.upstream != "" && (len(seen) != 1 || seen[0] != tt.upstream):
+				t.Errorf("%s %s: service saw %v, want %s", tt.method, tt.target, seen, tt.upstream)
+			}
+		})
+	}
+}
+
+func TestIsAdminPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		admin bool
+	}{
+		{"/api/admin", true},
+		{"/api/admin/", true},
+		{"/api/admin/v1/apps", true},
+		{"/API/ADMIN/v1/apps", true},
+		{"/api/administration", false},
+		{"/api/v3/admin", false},
+		{"/admin/v1/apps", false},
+		{"/api", false},
+	}
+
+	for _, tt := range tests {
+		if admin := isAdminPath(tt.path); admin != tt.admin {
+			t.Errorf("isAdminPath(%s) = %v, want %v", tt.path, admin, tt.admin)
+		}
+	}
+}
+
+func TestAdminPathsRefused(t *testing.T) {
+	var seen []string
+	tp := newTestProxy(t, `{
+		"id": "test",
+		"name": "Test",
+		"endpoints": {
+			"sonarr": {
+				"/api/v3/series": ["GET"],
+				"/api/admin/v1/apps": ["GET"]
+			}
+		}
+	}`, recordPaths(&seen))
+
+	// Every scheme is enabled, the refusal does not depend on the key.
+	tp.app.AuthSchemes = []string{string(tools.AuthSchemeHeader), string(tools.AuthSchemeQuery), string(tools.AuthSchemePath)}
+	if err := tp.repository.UpdateApp(int(tp.app.ID), tp.app); err != nil {
+		t.Fatal(err)
+	}
+
+	appKey, err := tp.repository.CreateAppKey(int(tp.app.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+	}{
+		{"header key", "/api/admin/v1/apps", http.Header{apiKeyHeaderKey: {testClientKey}}},
+		{"without a key", "/api/admin/v1/apps", nil},
+		{"path key on the root mount", "/k/" + testClientKey + "/api/admin/v1/apps", nil},
+		{"path key in another case", "/k/" + testClientKey + "/Api/Admin/v1/apps", nil},
+		{"query key", "/api/admin/v1/apps?apikey=" + testClientKey, nil},
+		{"service prefix", "/api/svc/sonarr/admin/v1/apps", http.Header{apiKeyHeaderKey: {appKey}}},
+		{"service prefix on the root mount", "/k/" + appKey + "/api/svc/sonarr/admin/v1/apps", nil},
+		{"dot segments on the root mount", "/k/" + testClientKey + "/api/v3/../admin/v1/apps", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+
+			w := serve("GET", tt.target, "", tt.header)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("GET %s: status %d, want %d", tt.target, w.Code, http.StatusNotFound)
+			}
+
+			if len(seen) > 0 {
+				t.Errorf("GET %s: admin request reached the service as %v", tt.target, seen)
+			}
+		})
+	}
+
+	// The other routes of the template stay available.
+	if w := serve("GET", "/k/"+testClientKey+"/api/v3/series", "", nil); w.Code != http.StatusOK {
+		t.Errorf("status %d, want %d", w.Code, http.StatusOK)
+	}
+}
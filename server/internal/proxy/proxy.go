@@ -3,11 +3,9 @@ package proxy
 import (
 	"fmt"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
-	"regexp"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/middlewarr/server/internal/models"
 	"github.com/middlewarr/server/internal/store"
@@ -16,24 +14,51 @@ import (
 )
 
 const (
-	apiKeyHeaderKey     string = "X-Api-Key"
-	apiKeyQueryParamKey string = "apikey"
+	apiKeyHeaderKey          string = "X-Api-Key"
+	apiKeyQueryParamKey      string = "apikey"
+	accessTokenQueryParamKey string = "access_token"
+	serviceHeaderKey         string = "X-Middlewarr-Service"
+	servicePathPrefix        string = "/api/svc/"
+	keyPathPrefix            string = "/k/"
+	adminPathPrefix          string = "/api/admin"
 )
 
+// ProxyEndpoint is the template endpoint matched by a request.
 type ProxyEndpoint struct {
-	Method    string
-	PathRegex *regexp.Regexp
+	*templates.RouteEndpoint
+	Params    map[string]string
+	Operation *tools.OpenAPIOperation
 }
 
-type ProxyEndpoints []ProxyEndpoint
-
 type ProxyConfig struct {
-	proxy     models.Proxy
-	endpoints ProxyEndpoints
+	proxy       models.Proxy
+	routes      *templates.RouteTrie
+	operations  map[*templates.RouteEndpoint]*tools.OpenAPIOperation
+	signalR     *templates.TemplateSignalR
+	commands    map[string]bool
+	specs       *tools.ServiceOpenAPISpec
+	schedule    *tools.Schedule
+	ipRules     []cidrRules
+	rateLimiter *rateLimiter
+	quotas      []quotaLimit
+	handler     http.Handler
+}
+
+// AppConfig routes the requests made with an app-level API key to the proxy
+// of the named service.
+type AppConfig struct {
+	app            models.App
+	proxyByService map[string]*ProxyConfig
 }
 
+// ProxyRouter indexes the proxies and apps by the hash of their API keys.
 type ProxyRouter struct {
 	ProxyByKey map[string]*ProxyConfig
+	AppByKey   map[string]*AppConfig
+	keyExpiry  map[string]time.Time
+	repository *store.ConfigurationRepository
+	transports map[uint]*http.Transport
+	balancers  map[uint]*balancer
 }
 
 var proxyRouter atomic.Value
@@ -42,8 +67,17 @@ func getProxyRouter() *ProxyRouter {
 	return proxyRouter.Load().(*ProxyRouter)
 }
 
+// setProxyRouter swaps the router and releases the idle connections of the
+// previous one, in-flight requests keep using their own transport.
 func setProxyRouter(pr *ProxyRouter) {
-	proxyRouter.Store(pr)
+	previous, _ := proxyRouter.Swap(pr).(*ProxyRouter)
+	if previous == nil {
+		return
+	}
+
+	for _, transport := range previous.transports {
+		transport.CloseIdleConnections()
+	}
 }
 
 func LoadProxy(c *store.ConfigurationRepository) {
@@ -54,10 +88,20 @@ func LoadProxy(c *store.ConfigurationRepository) {
 
 	pr := &ProxyRouter{
 		ProxyByKey: make(map[string]*ProxyConfig),
+		AppByKey:   make(map[string]*AppConfig),
+		keyExpiry:  make(map[string]time.Time),
+		repository: c,
+		transports: make(map[uint]*http.Transport),
+		balancers:  make(map[uint]*balancer),
 	}
 
 	store.ValidateConfig(c)
 
+	trustedProxies := getTrustedProxies()
+
+	proxyIDs := make(map[uint]bool)
+	serviceIDs := make(map[uint]bool)
+
 	// TODO: Handle errors
 	apps, _ := c.ReadApps()
 
@@ -72,19 +116,24 @@ func LoadProxy(c *store.ConfigurationRepository) {
 			continue
 		}
 
-		for _, proxy := range app.Proxies {
-			parsedEndpoints, err := parseEndpoints(template.Endpoints[proxy.Service.Type])
-			if err != nil {
-				l.Error().
-					Err(err).
-					Str("app_name", app.Name).
-					Str("app_template", app.Template).
-					Msg("Invalid template endpoint, no proxy will be configured")
+		schedule, err := tools.ParseAppSchedule(app.Schedule)
+		if err != nil {
+			l.Error().
+				Err(err).
+				Str("app_name", app.Name).
+				Msg("Invalid schedule, no proxy will be configured")
+			continue
+		}
 
-				continue
-			}
+		appConfig := &AppConfig{
+			app:            app,
+			proxyByService: make(map[string]*ProxyConfig),
+		}
 
-			if len(parsedEndpoints) == 0 {
+		for _, proxy := range app.Proxies {
+			routes := template.Routes(proxy.Service.Type)
+
+			if len(routes.Endpoints()) == 0 {
 				l.Warn().
 					Str("proxy_service", proxy.Service.Name).
 					Str("proxy_app", app.Name).
@@ -93,7 +142,23 @@ func LoadProxy(c *store.ConfigurationRepository) {
 					Msg("No endpoints present")
 			}
 
-			if proxy.APIKey == "" {
+			var specs *tools.ServiceOpenAPISpec
+			var operations map[*templates.RouteEndpoint]*tools.OpenAPIOperation
+			if template.Strict {
+				specs, operations, err = getStrictSpecs(proxy.Service.Type, routes)
+				if err != nil {
+					l.Error().
+						Err(err).
+						Str("proxy_service", proxy.Service.Name).
+						Str("proxy_app", app.Name).
+						Str("proxy_type", proxy.Service.Type).
+						Msg("Cannot load OpenAPI specs, no proxy will be configured")
+
+					continue
+				}
+			}
+
+			if proxy.APIKeyHash == "" {
 				l.Error().
 					Err(err).
 					Str("proxy_service", proxy.Service.Name).
@@ -105,67 +170,280 @@ func LoadProxy(c *store.ConfigurationRepository) {
 				continue
 			}
 
-			pr.ProxyByKey[proxy.APIKey] = &ProxyConfig{
-				proxy:     proxy,
-				endpoints: parsedEndpoints,
+			serviceKey, err := newServiceKey(proxy.Service)
+			if err != nil {
+				l.Error().
+					Err(err).
+					Str("proxy_service", proxy.Service.Name).
+					Str("proxy_app", app.Name).
+					Str("proxy_type", proxy.Service.Type).
+					Msg("Cannot decrypt the service API key, no proxy will be configured")
+
+				continue
+			}
+
+			ipRules, err := getIPRules(proxy, app)
+			if err != nil {
+				l.Error().
+					Err(err).
+					Str("proxy_service", proxy.Service.Name).
+					Str("proxy_app", app.Name).
+					Msg("Invalid CIDR rules, no proxy will be configured")
+
+				continue
+			}
+
+			lb, ok := pr.balancers[proxy.ServiceID]
+			if !ok {
+				lb, err = getBalancer(proxy.Service)
+				if err != nil {
+					l.Error().
+						Err(err).
+						Str("proxy_service", proxy.Service.Name).
+						Str("proxy_app", app.Name).
+						Str("proxy_type", proxy.Service.Type).
+						Str("proxy_url", proxy.Service.URL).
+						Msg("Invalid service URL, no proxy will be configured")
+
+					continue
+				}
+
+				pr.balancers[proxy.ServiceID] = lb
 			}
+
+			transport, ok := pr.transports[proxy.ServiceID]
+			if !ok {
+				transport = newTransport()
+				pr.transports[proxy.ServiceID] = transport
+			}
+
+			rateLimit, rateLimitBurst := getRateLimit(proxy, app)
+
+			var signalR *templates.TemplateSignalR
+			if s, ok := template.SignalR[proxy.Service.Type]; ok {
+				signalR = &s
+			}
+
+			var commands map[string]bool
+			if names, ok := template.Commands[proxy.Service.Type]; ok {
+				commands = newCommandAllowlist(names)
+			}
+
+			proxyConfig := &ProxyConfig{
+				proxy:       proxy,
+				schedule:    schedule,
+				ipRules:     ipRules,
+				routes:      routes,
+				operations:  operations,
+				signalR:     signalR,
+				commands:    commands,
+				specs:       specs,
+				rateLimiter: getRateLimiter(proxy.ID, rateLimit, rateLimitBurst),
+				quotas:      getQuotaLimits(proxy, app),
+			}
+
+			cb := getCircuitBreaker(proxy.Service)
+			rewriter := &responseRewriter{
+				scrubber: newSecretScrubber(proxy.Service.Type, serviceKey, template.Unmask[proxy.Service.Type]),
+			}
+
+			if template.RewriteUrls {
+				rewriter.urls = newURLRewriter(proxy.Service)
+			}
+
+			proxyConfig.handler = chainMiddlewares(
+				newReverseProxy(proxy.Service, newRetryTransport(&balancerTransport{transport, lb}), cb, rewriter),
+				middlewareLogRequest(),
+				middlewareIPFilter(proxyConfig.ipRules, trustedProxies),
+				middlewareValidateRequest(proxyConfig.routes, proxyConfig.operations),
+				middlewareValidateQuery(),
+				middlewareValidateBody(),
+				middlewareValidateSchema(proxyConfig.specs),
+				middlewareCommand(proxyConfig.commands),
+				middlewareSignalR(proxyConfig.signalR),
+				middlewareRateLimit(proxyConfig.rateLimiter),
+				middlewareQuota(c, proxy.ID, proxyConfig.quotas),
+				middlewareTimeout(),
+				middlewareCache(proxy.Service, serviceKey, rewriter),
+				middlewareCircuitBreaker(proxy.Service, cb),
+			)
+
+			pr.indexKey(proxy.APIKeyHash, proxy.APIKeyExpiresAt, proxyConfig)
+			for _, credential := range proxy.Credentials {
+				pr.indexKey(credential.APIKeyHash, credential.ExpiresAt, proxyConfig)
+			}
+			appConfig.proxyByService[strings.ToLower(proxy.Service.Name)] = proxyConfig
+
+			proxyIDs[proxy.ID] = true
+			serviceIDs[proxy.ServiceID] = true
+		}
+
+		if app.APIKeyHash != nil && *app.APIKeyHash != "" {
+			pr.AppByKey[*app.APIKeyHash] = appConfig
+		}
+	}
+
+	// A proxy key always designates its own service.
+	for apiKeyHash, appConfig := range pr.AppByKey {
+		if _, ok := pr.ProxyByKey[apiKeyHash]; ok {
+			l.Error().
+				Str("app_name", appConfig.app.Name).
+				Msg("App API key already used by a proxy, the app key will be ignored")
+
+			delete(pr.AppByKey, apiKeyHash)
 		}
 	}
 
 	setProxyRouter(pr)
+	pruneRateLimiters(proxyIDs)
+	pruneQuotaTrackers(c, proxyIDs)
+	pruneCircuitBreakers(serviceIDs)
+	pruneBalancers(serviceIDs)
 
 	l.Info().
 		Msg("Configuration loaded")
 }
 
-func parseEndpoints(endpoints map[string][]string) (ProxyEndpoints, error) {
-	var proxyEndpoints ProxyEndpoints
+// indexKey registers the hash of a proxy API key unless it already expired.
+func (pr *ProxyRouter) indexKey(apiKeyHash string, expiresAt *time.Time, proxyConfig *ProxyConfig) {
+	if expiresAt != nil {
+		if time.Now().After(*expiresAt) {
+			return
+		}
 
-	for path, methods := range endpoints {
-		regexStr := regexp.QuoteMeta(path)
-		// Replace placeholders like {id} with regex group
-		regexStr = strings.ReplaceAll(regexStr, `\{`, "{")
-		regexStr = strings.ReplaceAll(regexStr, `\}`, "}")
-		regexStr = regexp.MustCompile(`\{[^/]+\}`).ReplaceAllString(regexStr, `[^/]+`)
-		// Make the check case-insensitive
-		regexStr = `(?i)^` + regexStr + `$`
+		pr.keyExpiry[apiKeyHash] = *expiresAt
+	}
 
-		re, err := regexp.Compile(regexStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid endpoint pattern %s: %w", path, err)
-		}
+	pr.ProxyByKey[apiKeyHash] = proxyConfig
+}
+
+// getApiKey returns the client API key and the scheme it was provided with,
+// a key given in the path is stripped from the request URL.
+func getApiKey(r *http.Request) (string, tools.AuthScheme) {
+	if strings.HasPrefix(r.URL.Path, keyPathPrefix) {
+		apiKey, path, _ := strings.Cut(r.URL.Path[len(keyPathPrefix):], "/")
+		r.URL.Path = "/" + path
 
-		for _, m := range methods {
-			proxyEndpoints = append(proxyEndpoints, ProxyEndpoint{
-				Method:    strings.ToUpper(m),
-				PathRegex: re,
-			})
+		if r.URL.RawPath != "" {
+			_, rawPath, _ := strings.Cut(r.URL.RawPath[len(keyPathPrefix):], "/")
+			r.URL.RawPath = "/" + rawPath
 		}
+
+		return apiKey, tools.AuthSchemePath
+	}
+
+	if apiKey := r.Header.Get(apiKeyHeaderKey); apiKey != "" {
+		return apiKey, tools.AuthSchemeHeader
+	}
+
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token), tools.AuthSchemeBearer
+	}
+
+	if _, password, ok := r.BasicAuth(); ok {
+		return password, tools.AuthSchemeBasic
+	}
+
+	q := r.URL.Query()
+
+	if apiKey := q.Get(apiKeyQueryParamKey); apiKey != "" {
+		return apiKey, tools.AuthSchemeQuery
+	}
+
+	// SignalR clients authenticate with the `access_token` query param.
+	if apiKey := q.Get(accessTokenQueryParamKey); apiKey != "" && isSignalRPath(r.URL.Path) {
+		return apiKey, tools.AuthSchemeQuery
+	}
+
+	return "", ""
+}
+
+// getUnavailableReason tells why an active app cannot be used at the moment,
+// an empty reason means it can.
+func getUnavailableReason(app models.App, schedule *tools.Schedule, now time.Time) string {
+	if app.ExpiresAt != nil && !now.Before(*app.ExpiresAt) {
+		return fmt.Sprintf("app expired on %s", app.ExpiresAt.Format(time.RFC3339))
 	}
 
-	return proxyEndpoints, nil
+	if !schedule.IsOpen(now) {
+		return "app not available at this time"
+	}
+
+	return ""
 }
 
-func getApiKey(apiKeyHeaderValue string, apiKeyQueryParamValue string) *string {
-	if apiKeyHeaderValue != "" {
-		return &apiKeyHeaderValue
+// getRoutedService returns the service named by the request, either with the
+// `/api/svc/{serviceName}` path prefix, which is stripped, or the
+// X-Middlewarr-Service header. It reports whether the prefix was used.
+func getRoutedService(r *http.Request) (string, bool) {
+	if !strings.HasPrefix(strings.ToLower(r.URL.Path), servicePathPrefix) {
+		return r.Header.Get(serviceHeaderKey), false
 	}
 
-	if apiKeyQueryParamValue != "" {
-		return &apiKeyQueryParamValue
+	serviceName, path, _ := strings.Cut(r.URL.Path[len(servicePathPrefix):], "/")
+	r.URL.Path = "/api/" + path
+
+	if r.URL.RawPath != "" {
+		_, rawPath, _ := strings.Cut(r.URL.RawPath[len(servicePathPrefix):], "/")
+		r.URL.RawPath = "/api/" + rawPath
 	}
 
-	return nil
+	return serviceName, true
+}
+
+// isAdminPath tells whether a path belongs to the admin API, which is never
+// proxied even under another case or behind a key prefix.
+func isAdminPath(path string) bool {
+	path = strings.ToLower(path)
+
+	return path == adminPathPrefix || strings.HasPrefix(path, adminPathPrefix+"/")
 }
 
 func GetProxyHandle(w http.ResponseWriter, r *http.Request) {
 	l := tools.GetLogger()
 
-	apiKeyHeaderValue := r.Header.Get(apiKeyHeaderKey)
-	apiKeyQueryParamValue := r.URL.Query().Get(apiKeyQueryParamKey)
+	r = r.Clone(r.Context())
+
+	if err := canonicalizePath(r); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request, %s", err), http.StatusBadRequest)
+
+		l.Warn().
+			Err(err).
+			Str("request_client", r.RemoteAddr).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Msg("Ambiguous path")
+		return
+	}
+
+	if header := getMethodOverride(r); header != "" {
+		http.Error(w, fmt.Sprintf("Bad Request, %s header not allowed", header), http.StatusBadRequest)
+
+		l.Warn().
+			Str("request_client", r.RemoteAddr).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Str("header", header).
+			Msg("Method override")
+		return
+	}
 
-	apiKey := getApiKey(apiKeyHeaderValue, apiKeyQueryParamValue)
-	if apiKey == nil {
+	apiKey, authScheme := getApiKey(r)
+	serviceName, prefixed := getRoutedService(r)
+	r.Header.Del(serviceHeaderKey)
+
+	if isAdminPath(r.URL.Path) {
+		http.NotFound(w, r)
+
+		l.Warn().
+			Str("request_client", r.RemoteAddr).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Msg("Reserved path")
+		return
+	}
+
+	if apiKey == "" {
 		http.Error(w, "", http.StatusUnauthorized)
 
 		l.Error().
@@ -176,15 +454,59 @@ func GetProxyHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	proxyConfig, ok := getProxyRouter().ProxyByKey[*apiKey]
-	if !ok {
+	pr := getProxyRouter()
+	apiKeyHash := pr.repository.HashApiKey(apiKey)
+
+	proxyConfig, ok := pr.ProxyByKey[apiKeyHash]
+	if expiresAt, expires := pr.keyExpiry[apiKeyHash]; ok && expires && time.Now().After(expiresAt) {
 		http.Error(w, "", http.StatusUnauthorized)
 
+		l.Warn().
+			Str("request_client", r.RemoteAddr).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Time("expires_at", expiresAt).
+			Msg("Expired API key")
+		return
+	}
+
+	if !ok {
+		if appConfig, ok := pr.AppByKey[apiKeyHash]; ok {
+			proxyConfig = appConfig.proxyByService[strings.ToLower(serviceName)]
+		} else {
+			http.Error(w, "", http.StatusUnauthorized)
+
+			l.Error().
+				Str("request_client", r.RemoteAddr).
+				Str("request_method", r.Method).
+				Str("request_url", tools.SanitizeURI(r)).
+				Msg("Invalid API key")
+			return
+		}
+	} else if serviceName != "" && !strings.EqualFold(serviceName, proxyConfig.proxy.Service.Name) {
+		proxyConfig = nil
+	}
+
+	if proxyConfig == nil && serviceName == "" {
+		http.Error(w, fmt.Sprintf("Bad Request, missing %s header or %s{serviceName} prefix", serviceHeaderKey, servicePathPrefix), http.StatusBadRequest)
+
+		l.Error().
+			Str("request_client", r.RemoteAddr).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Msg("Missing service")
+		return
+	}
+
+	if proxyConfig == nil {
+		http.Error(w, fmt.Sprintf("Not Found, unknown service %s", serviceName), http.StatusNotFound)
+
 		l.Error().
 			Str("request_client", r.RemoteAddr).
 			Str("request_method", r.Method).
 			Str("request_url", tools.SanitizeURI(r)).
-			Msg("Invalid API key")
+			Str("request_service", serviceName).
+			Msg("Unknown service")
 		return
 	}
 
@@ -192,6 +514,18 @@ func GetProxyHandle(w http.ResponseWriter, r *http.Request) {
 	service := proxyConfig.proxy.Service
 	proxyID := fmt.Sprintf("%03d_%03d", app.ID, service.ID)
 
+	if !tools.IsAuthSchemeEnabled(app.AuthSchemes, authScheme) {
+		http.Error(w, "", http.StatusUnauthorized)
+
+		l.Warn().
+			Str("request_client", r.RemoteAddr).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Str("auth_scheme", string(authScheme)).
+			Msg("Authentication scheme not enabled")
+		return
+	}
+
 	if !*app.IsActive {
 		http.Error(w, "", http.StatusUnauthorized)
 
@@ -204,6 +538,19 @@ func GetProxyHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if reason := getUnavailableReason(app, proxyConfig.schedule, time.Now()); reason != "" {
+		http.Error(w, fmt.Sprintf("Forbidden, %s", reason), http.StatusForbidden)
+
+		l.Warn().
+			Str("request_client", r.RemoteAddr).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Str("proxy_app", app.Name).
+			Str("reason", reason).
+			Msg("App not available")
+		return
+	}
+
 	l.Info().
 		Str("proxy_service", service.Name).
 		Str("proxy_app", app.Name).
@@ -214,53 +561,21 @@ func GetProxyHandle(w http.ResponseWriter, r *http.Request) {
 		Str("request_url", tools.SanitizeURI(r)).
 		Msg("Proxing request")
 
-	serviceUrl, err := url.Parse(service.URL)
-	if err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
-
-		l.Error().
-			Err(err).
-			Str("proxy_service", service.Name).
-			Str("proxy_app", app.Name).
-			Str("proxy_type", service.Type).
-			Str("request_client", r.RemoteAddr).
-			Str("request_method", r.Method).
-			Str("request_url", tools.SanitizeURI(r)).
-			Msg("Invalid service URL")
-		return
+	// The client key must not reach the service.
+	if authScheme == tools.AuthSchemeBearer || authScheme == tools.AuthSchemeBasic {
+		r.Header.Del("Authorization")
 	}
 
-	var proxyHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		proxy := httputil.NewSingleHostReverseProxy(serviceUrl)
-		originalDirector := proxy.Director
-
-		proxy.Director = func(req *http.Request) {
-			originalDirector(req)
-
-			// Remove the `apikey` query param.
-			q := req.URL.Query()
-			q.Del(apiKeyQueryParamKey)
-
-			req.URL.RawQuery = q.Encode()
-
-			// Provide the API key using the X-Api-Key header.
-			req.Header.Set(apiKeyHeaderKey, service.APIKey)
-
-			req.Host = serviceUrl.Host
-		}
-
-		r.Header.Set("X-Proxy-Id", proxyID)
-		r.Header.Set("X-Proxy-App", app.Name)
-		r.Header.Set("X-Proxy-Service", service.Name)
+	info := &requestInfo{authScheme: authScheme, apiKey: apiKey}
+	if prefixed {
+		info.servicePrefix = servicePathPrefix + serviceName
+	}
 
-		handler := chainMiddlewares(
-			proxy,
-			middlewareLogRequest(),
-			middlewareValidateRequest(proxyConfig.endpoints),
-		)
+	r = withRequestInfo(r, info)
 
-		handler.ServeHTTP(w, r)
-	})
+	r.Header.Set("X-Proxy-Id", proxyID)
+	r.Header.Set("X-Proxy-App", app.Name)
+	r.Header.Set("X-Proxy-Service", service.Name)
 
-	proxyHandler.ServeHTTP(w, r)
+	proxyConfig.handler.ServeHTTP(w, r)
 }
@@ -0,0 +1,312 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/store"
+	"github.com/middlewarr/server/internal/templates"
+)
+
+const (
+	testClientKey  = "client0123456789abcdef0123456789ab"
+	testServiceKey = "service0123456789abcdef0123456789a"
+)
+
+const testSettings = `apiKey: test
+host: 127.0.0.1
+publicUrl: https://middlewarr.example.com/
+templates:
+  repository: https://github.com/middlewarr/templates
+  branch: main
+proxy:
+  trustedProxies:
+    - 192.0.2.0/24
+`
+
+// testSpecs is served in place of the OpenAPI specs of every service type.
+const testSpecs = `{
+	"paths": {
+		"/api/v3/series": {
+			"get": {"parameters": [{"name": "tvdbId", "in": "query", "schema": {"type": "integer", "format": "int32"}}]},
+			"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SeriesResource"}}}}}
+		},
+		"/api/v3/series/{id}": {
+			"get": {"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int32"}}]},
+			"put": {},
+			"delete": {}
+		},
+		"/api/v3/command": {"post": {}},
+		"/api/v3/system/status": {"get": {}},
+		"/api/v3/system/backup": {"get": {}}
+	},
+	"components": {
+		"schemas": {
+			"SeriesResource": {
+				"type": "object",
+				"properties": {
+					"title": {"type": "string", "nullable": true},
+					"tvdbId": {"type": "integer", "format": "int32"}
+				}
+			}
+		}
+	}
+}`
+
+type specsTransport struct {
+	next http.RoundTripper
+}
+
+func (t specsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host != "raw.githubusercontent.com" {
+		return t.next.RoundTrip(r)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(testSpecs)),
+		Request:    r,
+	}, nil
+}
+
+// TestMain runs the tests in a data directory of their own.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "middlewarr-proxy-")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join("data", "templates", "github.com", "middlewarr", "templates"), 0755); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join("data", "settings.yml"), []byte(testSettings), 0644); err != nil {
+		panic(err)
+	}
+
+	http.DefaultTransport = specsTransport{http.DefaultTransport}
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type testProxy struct {
+	repository *store.ConfigurationRepository
+	upstream   *httptest.Server
+	service    *models.Service
+	app        *models.App
+	proxy      *models.Proxy
+}
+
+// newTestProxy configures a single proxy using the template with an upstream
+// served by the handler, the health probes are answered by the test.
+func newTestProxy(t *testing.T, template string, handler http.HandlerFunc) *testProxy {
+	t.Helper()
+
+	file := filepath.Join("data", "templates", "github.com", "middlewarr", "templates", "test.json")
+	if err := os.WriteFile(file, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates.LoadTemplates()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/system/status" && r.Header.Get(apiKeyHeaderKey) == testServiceKey {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"appName":"Sonarr","version":"4.0.0"}`))
+			return
+		}
+
+		handler(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+
+	os.Remove(filepath.Join("data", "middlewarr.db"))
+	c := store.NewConfigurationRepository()
+
+	tp := &testProxy{
+		repository: c,
+		upstream:   upstream,
+		service:    &models.Service{Type: "sonarr", Name: "sonarr", URL: upstream.URL, APIKey: testServiceKey},
+	}
+
+	if err := c.CreateService(tp.service); err != nil {
+		t.Fatal(err)
+	}
+
+	active := true
+	tp.app = &models.App{Template: "test", Name: "app", IsActive: &active}
+	if err := c.CreateApp(tp.app); err != nil {
+		t.Fatal(err)
+	}
+
+	tp.proxy = &models.Proxy{AppID: tp.app.ID, ServiceID: tp.service.ID, APIKey: testClientKey}
+	if err := c.CreateProxy(tp.proxy); err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(c)
+
+	return tp
+}
+
+// serve sends a request through the proxy handler.
+func serve(method string, target string, body string, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = bytes.NewBufferString(body)
+	}
+
+	r := httptest.NewRequest(method, target, reader)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+
+	w := httptest.NewRecorder()
+	GetProxyHandle(w, r)
+
+	return w
+}
+
+// recordPaths is an upstream handler answering with the path it was sent.
+func recordPaths(seen *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*seen = append(*seen, r.Method+" "+r.URL.EscapedPath())
+		w.Write([]byte("ok"))
+	}
+}
+
+func TestGetRoutedService(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		header   string
+		service  string
+		prefixed bool
+		path     string
+		rawPath  string
+	}{
+		{"prefix", "/api/svc/sonarr/v3/series", "", "sonarr", true, "/api/v3/series", ""},
+		{"prefix in another case", "/API/SVC/Sonarr/v3/series", "", "Sonarr", true, "/api/v3/series", ""},
+		{"prefix over the header", "/api/svc/sonarr/v3/series", "radarr", "sonarr", true, "/api/v3/series", ""},
+		{"prefix without a path", "/api/svc/sonarr", "", "sonarr", true, "/api/", ""},
+		{"escaped path", "/api/svc/sonarr/v3/series/a%3Bb", "", "sonarr", true, "/api/v3/series/a;b", "/api/v3/series/a%3Bb"},
+		{"header", "/api/v3/series", "sonarr", "sonarr", false, "/api/v3/series", ""},
+		{"none", "/api/v3/series", "", "", false, "/api/v3/series", ""},
+		{"other api path", "/api/svcs/sonarr/v3/series", "", "", false, "/api/svcs/sonarr/v3/series", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set(serviceHeaderKey, tt.header)
+			}
+
+			service, prefixed := getRoutedService(r)
+
+			if service != tt.service || prefixed != tt.prefixed {
+				t.Errorf("getRoutedService() = %q, %v, want %q, %v", service, prefixed, tt.service, tt.prefixed)
+			}
+
+			if r.URL.Path != tt.path || r.URL.RawPath != tt.rawPath {
+				t.Errorf("path = %q (raw %q), want %q (raw %q)", r.URL.Path, r.URL.RawPath, tt.path, tt.rawPath)
+			}
+		})
+	}
+}
+
+func TestProxyServiceRouting(t *testing.T) {
+	var seen []string
+	tp := newTestProxy(t, pathTemplate, func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, "sonarr "+r.URL.Path)
+	})
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/system/status" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"appName":"Sonarr","version":"4.0.0"}`))
+			return
+		}
+
+		seen = append(seen, "anime "+r.URL.Path)
+	}))
+	t.Cleanup(other.Close)
+
+	service := &models.Service{Type: "sonarr", Name: "Anime", URL: other.URL, APIKey: testServiceKey}
+	if err := tp.repository.CreateService(service); err != nil {
+		t.Fatal(err)
+	}
+
+	const otherClientKey = "other0123456789abcdef0123456789abcd"
+	if err := tp.repository.CreateProxy(&models.Proxy{AppID: tp.app.ID, ServiceID: service.ID, APIKey: otherClientKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	appKey, err := tp.repository.CreateAppKey(int(tp.app.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	tests := []struct {
+		name     string
+		key      string
+		target   string
+		service  string
+		status   int
+		upstream string
+	}{
+		{"app key with a prefix", appKey, "/api/svc/sonarr/v3/series", "", http.StatusOK, "sonarr /api/v3/series"},
+		{"app key with another prefix", appKey, "/api/svc/anime/v3/series/1", "", http.StatusOK, "anime /api/v3/series/1"},
+		{"app key with a prefix in another case", appKey, "/api/svc/ANIME/v3/series", "", http.StatusOK, "anime /api/v3/series"},
+		{"app key with a header", appKey, "/api/v3/series", "Anime", http.StatusOK, "anime /api/v3/series"},
+		{"app key without a service", appKey, "/api/v3/series", "", http.StatusBadRequest, ""},
+		{"app key with an unknown service", appKey, "/api/svc/lidarr/v3/series", "", http.StatusNotFound, ""},
+		{"app key with an unknown header", appKey, "/api/v3/series", "lidarr", http.StatusNotFound, ""},
+		{"proxy key", testClientKey, "/api/v3/series", "", http.StatusOK, "sonarr /api/v3/series"},
+		{"proxy key with its service", testClientKey, "/api/svc/Sonarr/v3/series", "", http.StatusOK, "sonarr /api/v3/series"},
+		{"proxy key with another service", testClientKey, "/api/svc/anime/v3/series", "", http.StatusNotFound, ""},
+		{"proxy key with another header", otherClientKey, "/api/v3/series", "sonarr", http.StatusNotFound, ""},
+		{"prefix stripped before the routes", appKey, "/api/svc/sonarr/v3/system/backup", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+
+			header := http.Header{apiKeyHeaderKey: {tt.key}}
+			if tt.service != "" {
+				header.Set(serviceHeaderKey, tt.service)
+			}
+
+			w := serve("GET", tt.target, "", header)
+
+			if w.Code != tt.status {
+				t.Fatalf("GET %s: status %d, want %d (%s)", tt.target, w.Code, tt.status, w.Body.String())
+			}
+
+			switch {
+			case tt.upstream == "" && len(seen) > 0:
+				t.Errorf("GET %s: refused request reached %v", tt.target, seen)
+			case tt.upstream != "" && (len(seen) != 1 || seen[0] != tt.upstream):
+				t.Errorf("GET %s: services saw %v, want %s", tt.target, seen, tt.upstream)
+			}
+		})
+	}
+}
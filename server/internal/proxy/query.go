@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/middlewarr/server/internal/templates"
+)
+
+type queryViolation struct {
+	Parameter string `json:"parameter"`
+	Value     string `json:"value,omitempty"`
+	Rule      string `json:"rule"`
+}
+
+// getQueryParam looks a parameter up regardless of its case, as the *arr
+// services do.
+func getQueryParam(params map[string]templates.TemplateQueryParam, key string) (templates.TemplateQueryParam, bool) {
+	if param, ok := params[key]; ok {
+		return param, true
+	}
+
+	for name, param := range params {
+		if strings.EqualFold(name, key) {
+			return param, true
+		}
+	}
+
+	return templates.TemplateQueryParam{}, false
+}
+
+// Validate Query
+func middlewareValidateQuery() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			endpoint := getRequestInfo(r).endpoint
+			if endpoint == nil || endpoint.Route.Query == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			q := r.URL.Query()
+			rewritten := false
+
+			for key, values := range q {
+				// Client credentials are removed before reaching the service.
+				if key == apiKeyQueryParamKey || key == accessTokenQueryParamKey {
+					continue
+				}
+
+				param, ok := getQueryParam(endpoint.Route.Query, key)
+				if !ok {
+					violation := queryViolation{Parameter: key, Rule: "not allowed"}
+					errorHandler(w, http.StatusForbidden, fmt.Sprintf("Forbidden, query parameter %s not allowed", key), violation)
+					return
+				}
+
+				for i, value := range values {
+					validated, err := param.Validate(value)
+					if err != nil {
+						violation := queryViolation{Parameter: key, Value: value, Rule: err.Error()}
+						errorHandler(w, http.StatusForbidden, fmt.Sprintf("Forbidden, query parameter %s %s", key, err), violation)
+						return
+					}
+
+					if validated != value {
+						values[i] = validated
+						rewritten = true
+					}
+				}
+			}
+
+			if rewritten {
+				r.URL.RawQuery = q.Encode()
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+const queryTemplate = `{
+	"id": "test",
+	"name": "Test",
+	"endpoints": {
+		"sonarr": {
+			"/api/v3/series": {
+				"methods": ["GET"],
+				"query": {
+					"tvdbId": {"pattern": "[0-9]+"},
+					"pageSize": {"min": 1, "max": 50, "clamp": true},
+					"sortDirection": {"enum": ["ascending", "descending"]}
+				}
+			},
+			"/api/v3/series/{id}": ["GET"]
+		}
+	}
+}`
+
+func TestMiddlewareValidateQuery(t *testing.T) {
+	var seen []string
+	newTestProxy(t, queryTemplate, func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.URL.RawQuery)
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		upstream string
+	}{
+		{"none", "", http.StatusOK, ""},
+		{"valid", "tvdbId=81189&sortDirection=ascending", http.StatusOK, "sortDirection=ascending&tvdbId=81189"},
+		{"any case", "TVDBID=81189", http.StatusOK, "TVDBID=81189"},
+		{"clamped", "pageSize=1000", http.StatusOK, "pageSize=50"},
+		{"clamped repeated", "pageSize=10&pageSize=0", http.StatusOK, "pageSize=10&pageSize=1"},
+		{"not allowed", "includeEpisodeFile=true", http.StatusForbidden, ""},
+		{"pattern", "tvdbId=81189x", http.StatusForbidden, ""},
+		{"enum", "sortDirection=random", http.StatusForbidden, ""},
+		{"not a number", "pageSize=all", http.StatusForbidden, ""},
+		{"client key", "apikey=" + testClientKey, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+
+			target := "/api/v3/series"
+			if tt.query != "" {
+				target += "?" + tt.query
+			}
+
+			w := serve("GET", target, "", http.Header{apiKeyHeaderKey: {testClientKey}})
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if tt.status != http.StatusOK {
+				if len(seen) != 0 {
+					t.Errorf("forwarded %v", seen)
+				}
+
+				return
+			}
+
+			if len(seen) != 1 || seen[0] != tt.upstream {
+				t.Errorf("upstream query %v, want %q", seen, tt.upstream)
+			}
+		})
+	}
+
+	// Routes without query rules let any parameter through.
+	seen = nil
+	if w := serve("GET", "/api/v3/series/42?anything=1", "", http.Header{apiKeyHeaderKey: {testClientKey}}); w.Code != http.StatusOK || len(seen) != 1 {
+		t.Errorf("status %d forwarding %v", w.Code, seen)
+	}
+}
@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/store"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	quotaPeriodDaily   string = "daily"
+	quotaPeriodMonthly string = "monthly"
+
+	defaultQuotaFlushInterval time.Duration = 10 * time.Second
+)
+
+type quotaLimit struct {
+	period string
+	limit  int
+}
+
+type ProxyQuota struct {
+	Period  string    `json:"period"`
+	Limit   int       `json:"limit"`
+	Used    int       `json:"used"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// Request counters of the current periods, keyed by proxy ID. They are read
+// from the database once, then the in-memory copy is authoritative: it
+// serializes check-and-increment and the requests counted are written in
+// batches, at most a flush interval of them is lost when the server stops
+// abruptly.
+type quotaTracker struct {
+	mu     sync.Mutex
+	counts map[string]int
+	// Requests counted since the last flush, keyed like the counts.
+	pending map[string]int
+}
+
+var quotaTrackers sync.Map
+
+var onceQuotaFlush sync.Once
+
+func getQuotaLimits(proxy models.Proxy, app models.App) []quotaLimit {
+	daily := app.DailyQuota
+	monthly := app.MonthlyQuota
+
+	if proxy.DailyQuota != nil {
+		daily = proxy.DailyQuota
+	}
+
+	if proxy.MonthlyQuota != nil {
+		monthly = proxy.MonthlyQuota
+	}
+
+	var limits []quotaLimit
+
+	if daily != nil && *daily > 0 {
+		limits = append(limits, quotaLimit{quotaPeriodDaily, *daily})
+	}
+
+	if monthly != nil && *monthly > 0 {
+		limits = append(limits, quotaLimit{quotaPeriodMonthly, *monthly})
+	}
+
+	return limits
+}
+
+func getQuotaPeriod(period string, now time.Time) (string, time.Time) {
+	year, month, day := now.Date()
+
+	switch period {
+	case quotaPeriodMonthly:
+		return now.Format("2006-01"), time.Date(year, month+1, 1, 0, 0, 0, 0, now.Location())
+	default:
+		return now.Format("2006-01-02"), time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	}
+}
+
+func getQuotaTracker(proxyID uint) *quotaTracker {
+	v, _ := quotaTrackers.LoadOrStore(proxyID, &quotaTracker{
+		counts:  make(map[string]int),
+		pending: make(map[string]int),
+	})
+
+	return v.(*quotaTracker)
+}
+
+func pruneQuotaTrackers(c *store.ConfigurationRepository, proxyIDs map[uint]bool) {
+	quotaTrackers.Range(func(key, v any) bool {
+		if !proxyIDs[key.(uint)] {
+			quotaTrackers.Delete(key)
+			v.(*quotaTracker).flush(c, key.(uint))
+		}
+
+		return true
+	})
+}
+
+// flush writes the requests counted since the last flush, the ones that
+// cannot be written are kept for the next one.
+func (q *quotaTracker) flush(c *store.ConfigurationRepository, proxyID uint) {
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = make(map[string]int)
+	q.mu.Unlock()
+
+	for mapKey, count := range pending {
+		period, key, _ := strings.Cut(mapKey, "/")
+
+		err := c.AddProxyUsage(proxyID, period, key, count)
+		if err != nil {
+			l := tools.GetLogger()
+
+			l.Error().
+				Err(err).
+				Uint("proxy_id", proxyID).
+				Msg("Cannot update proxy usage")
+
+			q.mu.Lock()
+			q.pending[mapKey] += count
+			q.mu.Unlock()
+		}
+	}
+}
+
+// FlushQuotaUsages writes the requests counted by every proxy.
+func FlushQuotaUsages(c *store.ConfigurationRepository) {
+	quotaTrackers.Range(func(key, v any) bool {
+		v.(*quotaTracker).flush(c, key.(uint))
+
+		return true
+	})
+}
+
+func runQuotaFlush(c *store.ConfigurationRepository) {
+	interval := getSettingsDuration("proxy.quota.flushInterval", defaultQuotaFlushInterval)
+
+	for range time.Tick(interval) {
+		FlushQuotaUsages(c)
+	}
+}
+
+// loaded returns the count of a period when it is in memory.
+func (q *quotaTracker) loaded(period string, key string) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count, ok := q.counts[period+"/"+key]
+
+	return count, ok
+}
+
+// loadQuotaUsage reads the count of a period from the database and deletes
+// the counts of the previous ones. It runs without the lock of the tracker,
+// the requests of the proxy are not held behind the database.
+func loadQuotaUsage(c *store.ConfigurationRepository, proxyID uint, period string, key string) (int, error) {
+	usage, err := c.ReadProxyUsage(proxyID, period, key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.DestroyProxyUsages(proxyID, period, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return usage.Count, nil
+}
+
+// merge stores a count loaded from the database, unless a concurrent request
+// loaded it first. Must be called with the lock held.
+func (q *quotaTracker) merge(period string, key string, count int) {
+	mapKey := period + "/" + key
+
+	if _, ok := q.counts[mapKey]; ok {
+		return
+	}
+
+	// A new period started, forget the previous one.
+	for k := range q.counts {
+		if strings.HasPrefix(k, period+"/") {
+			delete(q.counts, k)
+			delete(q.pending, k)
+		}
+	}
+
+	q.counts[mapKey] = count
+}
+
+// consume checks every quota of the proxy and counts the request against all
+// of them. It returns the first exhausted quota, if any. The quotas are
+// checked before the cache: the responses served from it count too, a quota
+// bounds the requests of the app whatever serves them.
+func (q *quotaTracker) consume(c *store.ConfigurationRepository, proxyID uint, limits []quotaLimit) (*ProxyQuota, error) {
+	now := time.Now()
+
+	keys := make([]string, len(limits))
+	resets := make([]time.Time, len(limits))
+
+	for i, limit := range limits {
+		keys[i], resets[i] = getQuotaPeriod(limit.period, now)
+	}
+
+	q.mu.Lock()
+
+	var missing []int
+	for i, limit := range limits {
+		if _, ok := q.counts[limit.period+"/"+keys[i]]; !ok {
+			missing = append(missing, i)
+		}
+	}
+
+	q.mu.Unlock()
+
+	loaded := make(map[int]int)
+	for _, i := range missing {
+		count, err := loadQuotaUsage(c, proxyID, limits[i].period, keys[i])
+		if err != nil {
+			return nil, err
+		}
+
+		loaded[i] = count
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, count := range loaded {
+		q.merge(limits[i].period, keys[i], count)
+	}
+
+	for i, limit := range limits {
+		if count := q.counts[limit.period+"/"+keys[i]]; count >= limit.limit {
+			return &ProxyQuota{
+				Period:  limit.period,
+				Limit:   limit.limit,
+				Used:    count,
+				ResetAt: resets[i],
+			}, nil
+		}
+	}
+
+	for i, limit := range limits {
+		q.counts[limit.period+"/"+keys[i]]++
+		q.pending[limit.period+"/"+keys[i]]++
+	}
+
+	return nil, nil
+}
+
+func ReadProxyQuotas(c *store.ConfigurationRepository, proxy *models.Proxy) (*[]ProxyQuota, error) {
+	now := time.Now()
+
+	quotas := []ProxyQuota{}
+
+	for _, limit := range getQuotaLimits(*proxy, proxy.App) {
+		key, resetAt := getQuotaPeriod(limit.period, now)
+
+		usage, err := c.ReadProxyUsage(proxy.ID, limit.period, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// The requests counted since the last flush are only in memory.
+		if v, ok := quotaTrackers.Load(proxy.ID); ok {
+			if count, ok := v.(*quotaTracker).loaded(limit.period, key); ok {
+				usage.Count = count
+			}
+		}
+
+		quotas = append(quotas, ProxyQuota{
+			Period:  limit.period,
+			Limit:   limit.limit,
+			Used:    usage.Count,
+			ResetAt: resetAt,
+		})
+	}
+
+	return &quotas, nil
+}
+
+// Quota
+func middlewareQuota(c *store.ConfigurationRepository, proxyID uint, limits []quotaLimit) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(limits) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			onceQuotaFlush.Do(func() {
+				go runQuotaFlush(c)
+			})
+
+			exceeded, err := getQuotaTracker(proxyID).consume(c, proxyID, limits)
+			if err != nil {
+				l := tools.GetLogger()
+
+				// Do not block the app because of a storage error.
+				l.Error().
+					Err(err).
+					Uint("proxy_id", proxyID).
+					Msg("Cannot read proxy usage")
+			}
+
+			if exceeded != nil {
+				retryAfter := time.Until(exceeded.ResetAt)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+				message := fmt.Sprintf("Too Many Requests, %s quota of %d requests exceeded", exceeded.Period, exceeded.Limit)
+				errorHandler(w, http.StatusTooManyRequests, message, exceeded)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
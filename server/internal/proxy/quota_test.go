@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const quotaTemplate = `{
+	"id": "test",
+	"name": "Test",
+	"endpoints": {
+		"sonarr": {
+			"/api/v3/series/{id}": {"methods": ["GET"], "cache": {"ttl": "1m"}},
+			"/api/v3/series": ["GET"]
+		}
+	}
+}`
+
+func TestQuotaCountsCachedResponses(t *testing.T) {
+	var seen []string
+	tp := newTestProxy(t, quotaTemplate, recordPaths(&seen))
+
+	quotaTrackers.Delete(tp.proxy.ID)
+	t.Cleanup(func() { quotaTrackers.Delete(tp.proxy.ID) })
+
+	quota := 2
+	tp.proxy.DailyQuota = &quota
+	if err := tp.repository.UpdateProxy(int(tp.proxy.ID), tp.proxy); err != nil {
+		t.Fatal(err)
+	}
+
+	LoadProxy(tp.repository)
+
+	header := http.Header{apiKeyHeaderKey: {testClientKey}}
+
+	for i, status := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := serve("GET", "/api/v3/series/42", "", header); w.Code != status {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, status)
+		}
+	}
+
+	if len(seen) != 1 {
+		t.Errorf("service saw %v, want a single request", seen)
+	}
+
+	key, _ := getQuotaPeriod(quotaPeriodDaily, time.Now())
+
+	usage, err := tp.repository.ReadProxyUsage(tp.proxy.ID, quotaPeriodDaily, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Count != 0 {
+		t.Errorf("%d requests written before the flush", usage.Count)
+	}
+
+	p, err := tp.repository.ReadProxy(int(tp.proxy.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quotas, err := ReadProxyQuotas(tp.repository, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*quotas) != 1 || (*quotas)[0].Used != 2 {
+		t.Errorf("quotas %+v, want 2 requests used", *quotas)
+	}
+
+	FlushQuotaUsages(tp.repository)
+
+	usage, err = tp.repository.ReadProxyUsage(tp.proxy.ID, quotaPeriodDaily, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Count != 2 {
+		t.Errorf("%d requests written, want 2", usage.Count)
+	}
+}
+
+func TestQuotaTracker(t *testing.T) {
+	tp := newTestProxy(t, quotaTemplate, recordPaths(new([]string)))
+
+	quotaTrackers.Delete(tp.proxy.ID)
+	t.Cleanup(func() { quotaTrackers.Delete(tp.proxy.ID) })
+
+	today, _ := getQuotaPeriod(quotaPeriodDaily, time.Now())
+	month, _ := getQuotaPeriod(quotaPeriodMonthly, time.Now())
+
+	// A previous day and the requests of the month written by a previous run.
+	if err := tp.repository.AddProxyUsage(tp.proxy.ID, quotaPeriodDaily, "2000-01-01", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tp.repository.AddProxyUsage(tp.proxy.ID, quotaPeriodMonthly, month, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	limits := []quotaLimit{{quotaPeriodDaily, 2}, {quotaPeriodMonthly, 4}}
+
+	tests := []struct {
+		name     string
+		exceeded string
+		used     int
+	}{
+		{"first of the day", "", 0},
+		{"second of the day", "", 0},
+		{"daily quota exhausted", quotaPeriodDaily, 2},
+	}
+
+	tracker := getQuotaTracker(tp.proxy.ID)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exceeded, err := tracker.consume(tp.repository, tp.proxy.ID, limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.exceeded == "" && exceeded != nil:
+				t.Fatalf("%s quota exceeded", exceeded.Period)
+			case tt.exceeded != "" && (exceeded == nil || exceeded.Period != tt.exceeded || exceeded.Used != tt.used):
+				t.Fatalf("exceeded %+v, want the %s quota with %d requests", exceeded, tt.exceeded, tt.used)
+			}
+		})
+	}
+
+	// The monthly quota is exhausted first once a new day starts.
+	tracker.counts[quotaPeriodDaily+"/"+today] = 0
+
+	if exceeded, _ := tracker.consume(tp.repository, tp.proxy.ID, limits); exceeded == nil || exceeded.Period != quotaPeriodMonthly || exceeded.Used != 4 {
+		t.Errorf("exceeded %+v, want the monthly quota with 4 requests", exceeded)
+	}
+
+	FlushQuotaUsages(tp.repository)
+
+	for _, tt := range []struct {
+		period string
+		key    string
+		count  int
+	}{
+		{quotaPeriodDaily, "2000-01-01", 0},
+		{quotaPeriodDaily, today, 2},
+		{quotaPeriodMonthly, month, 4},
+	} {
+		usage, err := tp.repository.ReadProxyUsage(tp.proxy.ID, tt.period, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if usage.Count != tt.count {
+			t.Errorf("%s %s: %d requests written, want %d", tt.period, tt.key, usage.Count, tt.count)
+		}
+	}
+}
+
+func TestQuotaTrackerConcurrentLoads(t *testing.T) {
+	tp := newTestProxy(t, quotaTemplate, recordPaths(new([]string)))
+
+	quotaTrackers.Delete(tp.proxy.ID)
+	t.Cleanup(func() { quotaTrackers.Delete(tp.proxy.ID) })
+
+	today, _ := getQuotaPeriod(quotaPeriodDaily, time.Now())
+	if err := tp.repository.AddProxyUsage(tp.proxy.ID, quotaPeriodDaily, today, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := getQuotaTracker(tp.proxy.ID)
+	limits := []quotaLimit{{quotaPeriodDaily, 20}}
+
+	// The requests racing to load the count from the database all see it
+	// once: every request is counted exactly once.
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+
+	for range 40 {
+		wg.Go(func() {
+			if exceeded, err := tracker.consume(tp.repository, tp.proxy.ID, limits); err == nil && exceeded == nil {
+				allowed.Add(1)
+			}
+		})
+	}
+
+	wg.Wait()
+
+	if allowed.Load() != 15 {
+		t.Errorf("%d requests allowed, want 15", allowed.Load())
+	}
+
+	// A count loaded late does not replace the one already in memory.
+	tracker.mu.Lock()
+	tracker.merge(quotaPeriodDaily, today, 5)
+	count := tracker.counts[quotaPeriodDaily+"/"+today]
+	tracker.mu.Unlock()
+
+	if count != 20 {
+		t.Errorf("count %d after a late load, want 20", count)
+	}
+}
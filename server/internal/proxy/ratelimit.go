@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+// Token bucket refilled at `limit` requests per minute, holding up to `burst`
+// tokens. Buckets are keyed by proxy ID and kept across LoadProxy calls so a
+// configuration reload does not hand a fresh burst to every app.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+var rateLimiters sync.Map
+
+func getRateLimit(proxy models.Proxy, app models.App) (int, int) {
+	limit := 0
+	burst := 0
+
+	if app.RateLimit != nil {
+		limit = *app.RateLimit
+	}
+
+	if app.RateLimitBurst != nil {
+		burst = *app.RateLimitBurst
+	}
+
+	if proxy.RateLimit != nil {
+		limit = *proxy.RateLimit
+	}
+
+	if proxy.RateLimitBurst != nil {
+		burst = *proxy.RateLimitBurst
+	}
+
+	if burst <= 0 {
+		burst = limit
+	}
+
+	return limit, burst
+}
+
+func getRateLimiter(proxyID uint, limit int, burst int) *rateLimiter {
+	if limit <= 0 {
+		rateLimiters.Delete(proxyID)
+		return nil
+	}
+
+	v, _ := rateLimiters.LoadOrStore(proxyID, &rateLimiter{
+		limit:  limit,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	})
+
+	rl := v.(*rateLimiter)
+	rl.configure(limit, burst)
+
+	return rl
+}
+
+func pruneRateLimiters(proxyIDs map[uint]bool) {
+	rateLimiters.Range(func(key, _ any) bool {
+		if !proxyIDs[key.(uint)] {
+			rateLimiters.Delete(key)
+		}
+
+		return true
+	})
+}
+
+func (rl *rateLimiter) configure(limit int, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill(time.Now())
+
+	rl.limit = limit
+	rl.burst = burst
+	rl.tokens = math.Min(rl.tokens, float64(burst))
+}
+
+func (rl *rateLimiter) rate() float64 {
+	return float64(rl.limit) / 60
+}
+
+func (rl *rateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(rl.last).Seconds()
+	if elapsed > 0 {
+		rl.tokens = math.Min(rl.tokens+elapsed*rl.rate(), float64(rl.burst))
+	}
+
+	rl.last = now
+}
+
+type rateLimitStatus struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// allow consumes a token if one is available. The returned status also holds
+// the delay before the next token and the delay before the bucket is full.
+func (rl *rateLimiter) allow() rateLimitStatus {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill(time.Now())
+
+	status := rateLimitStatus{
+		allowed: rl.tokens >= 1,
+		limit:   rl.limit,
+	}
+
+	if status.allowed {
+		rl.tokens--
+	}
+
+	if rl.tokens < 1 {
+		status.retryAfter = time.Duration((1 - rl.tokens) / rl.rate() * float64(time.Second))
+	}
+
+	status.remaining = int(rl.tokens)
+	status.reset = time.Duration((float64(rl.burst) - rl.tokens) / rl.rate() * float64(time.Second))
+
+	return status
+}
+
+// Rate Limit
+func middlewareRateLimit(rl *rateLimiter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rl == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			status := rl.allow()
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(status.limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(status.reset.Seconds()))))
+
+			if !status.allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.retryAfter.Seconds()))))
+				http.Error(w, fmt.Sprintf("Too Many Requests, limit of %d requests per minute exceeded", status.limit), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+func TestGetRateLimit(t *testing.T) {
+	value := func(v int) *int { return &v }
+
+	tests := []struct {
+		name  string
+		proxy models.Proxy
+		app   models.App
+		limit int
+		burst int
+	}{
+		{"none", models.Proxy{}, models.App{}, 0, 0},
+		{"app", models.Proxy{}, models.App{RateLimit: value(60)}, 60, 60},
+		{"app burst", models.Proxy{}, models.App{RateLimit: value(60), RateLimitBurst: value(10)}, 60, 10},
+		{"proxy over the app", models.Proxy{RateLimit: value(30)}, models.App{RateLimit: value(60), RateLimitBurst: value(10)}, 30, 10},
+		{"proxy burst", models.Proxy{RateLimitBurst: value(5)}, models.App{RateLimit: value(60)}, 60, 5},
+		{"burst without limit", models.Proxy{}, models.App{RateLimitBurst: value(5)}, 0, 5},
+		{"negative burst", models.Proxy{}, models.App{RateLimit: value(60), RateLimitBurst: value(-1)}, 60, 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, burst := getRateLimit(tt.proxy, tt.app)
+			if limit != tt.limit || burst != tt.burst {
+				t.Errorf("getRateLimit() = %d, %d, want %d, %d", limit, burst, tt.limit, tt.burst)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	// 60 requests per minute, one token a second.
+	rl := &rateLimiter{limit: 60, burst: 3, tokens: 3, last: time.Now()}
+
+	tests := []struct {
+		name      string
+		elapsed   time.Duration
+		allowed   bool
+		remaining int
+	}{
+		{"first of the burst", 0, true, 2},
+		{"second of the burst", 0, true, 1},
+		{"last of the burst", 0, true, 0},
+		{"burst exhausted", 0, false, 0},
+		{"refilled", time.Second, true, 0},
+		{"half refilled", 500 * time.Millisecond, false, 0},
+		{"refilled up to the burst", time.Hour, true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl.last = rl.last.Add(-tt.elapsed)
+
+			status := rl.allow()
+
+			if status.allowed != tt.allowed || status.remaining != tt.remaining {
+				t.Fatalf("allowed %v with %d remaining, want %v with %d", status.allowed, status.remaining, tt.allowed, tt.remaining)
+			}
+
+			if !status.allowed && (status.retryAfter <= 0 || status.retryAfter > time.Second) {
+				t.Errorf("retry after %s", status.retryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiterReconfigure(t *testing.T) {
+	const proxyID = 1 << 20
+	defer rateLimiters.Delete(uint(proxyID))
+
+	rl := getRateLimiter(proxyID, 60, 10)
+	for range 8 {
+		rl.allow()
+	}
+
+	// A reload keeps the tokens consumed, within the new burst.
+	if again := getRateLimiter(proxyID, 60, 5); again != rl || int(rl.tokens) != 2 {
+		t.Errorf("%v tokens after a reload, want 2", rl.tokens)
+	}
+
+	if again := getRateLimiter(proxyID, 60, 1); int(again.tokens) != 1 {
+		t.Errorf("%v tokens after lowering the burst, want 1", again.tokens)
+	}
+
+	if getRateLimiter(proxyID, 0, 0) != nil {
+		t.Error("rate limiter without limit")
+	}
+
+	if _, ok := rateLimiters.Load(uint(proxyID)); ok {
+		t.Error("rate limiter kept once disabled")
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	rl := &rateLimiter{limit: 30, burst: 1, tokens: 1, last: time.Now()}
+	handler := middlewareRateLimit(rl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		status     int
+		remaining  string
+		retryAfter string
+	}{
+		{http.StatusOK, "0", ""},
+		{http.StatusTooManyRequests, "0", "2"},
+	}
+
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v3/series", nil))
+
+		if w.Code != tt.status {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, tt.status)
+		}
+
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "30" {
+			t.Errorf("request %d: X-RateLimit-Limit %s, want 30", i+1, got)
+		}
+
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("request %d: X-RateLimit-Remaining %s, want %s", i+1, got, tt.remaining)
+		}
+
+		if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("request %d: Retry-After %s, want %s", i+1, got, tt.retryAfter)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	middlewareRateLimit(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Error("requests limited without rate limit")
+	}
+}
@@ -0,0 +1,419 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/middlewarr/server/internal/templates"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+var errResponseTransform = errors.New("cannot rewrite the response")
+
+// Responses are buffered to be rewritten. Larger ones are forwarded as is,
+// unless the route transforms them: those are refused.
+var maxTransformedBodySize int64 = 64 << 20
+
+var errResponseTooLarge = errors.New("response too large to be rewritten")
+
+// acceptRewritableEncoding asks the service for a response which can be
+// rewritten, either plain or gzip encoded.
+func acceptRewritableEncoding(req *http.Request) {
+	if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		req.Header.Set("Accept-Encoding", "gzip")
+	} else {
+		req.Header.Del("Accept-Encoding")
+	}
+}
+
+type responseKind int
+
+const (
+	responseOther responseKind = iota
+	responseJSON
+	// Newznab/Torznab and RSS feeds, iCal calendars.
+	responseText
+)
+
+func getResponseKind(res *http.Response) responseKind {
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return responseOther
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "text/json":
+		return responseJSON
+	case mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml") || mediaType == "text/xml",
+		mediaType == "text/calendar" || mediaType == "text/plain":
+		return responseText
+	}
+
+	return responseOther
+}
+
+// responseRewriter gathers the rewrites of the responses of a proxy.
+type responseRewriter struct {
+	scrubber *secretScrubber
+	urls     *urlRewriter
+}
+
+// cacheKey tells apart the responses which are rewritten differently.
+func (rw *responseRewriter) cacheKey(r *http.Request) string {
+	info := getRequestInfo(r)
+
+	key := rw.scrubber.identity
+	if rw.urls != nil {
+		key += rw.urls.public + info.servicePrefix
+	}
+
+	if info.endpoint != nil && info.endpoint.Route.Response != nil {
+		key += info.endpoint.Route.Response.Identity()
+	}
+
+	// The feeds carry the key of the client, they are not shared.
+	if !templates.IsAPIPath(r.URL.Path) {
+		key += hex.EncodeToString(hashSecret(info.apiKey)[:8])
+	}
+
+	return key
+}
+
+// modify rewrites the Location header and, for a successful JSON response,
+// applies the transform of the route, rewrites the URLs and masks the
+// secrets. Feeds only get their URLs replaced and the service API key swapped
+// for the client one, so that their download links go through the proxy too.
+// The other responses, the streamed ones included, get the service API key
+// masked as they are read. An error stops the response from reaching the
+// client.
+func (rw *responseRewriter) modify(res *http.Response) error {
+	info := getRequestInfo(res.Request)
+
+	var replacer *urlReplacer
+	if rw.urls != nil {
+		replacer = rw.urls.replacer(info.servicePrefix)
+		rewriteLocation(res, replacer)
+	}
+
+	var transform *templates.TemplateResponse
+	if info.endpoint != nil {
+		transform = info.endpoint.Route.Response
+
+		// Streamed responses reach the client as they come.
+		if transform == nil && info.endpoint.Route.Stream {
+			if err := rw.filterBody(res); err != nil {
+				return fmt.Errorf("%w: %w", errResponseTransform, err)
+			}
+
+			return nil
+		}
+	}
+
+	if err := rw.rewriteBody(res, transform, replacer, info.apiKey); err != nil {
+		return fmt.Errorf("%w: %w", errResponseTransform, err)
+	}
+
+	return nil
+}
+
+// readLimited reads a body up to the size of the responses which are
+// rewritten, complete is false when there is more to read.
+func readLimited(r io.Reader) (body []byte, complete bool, err error) {
+	body, err = io.ReadAll(io.LimitReader(r, maxTransformedBodySize+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(body)) > maxTransformedBodySize {
+		return body, false, nil
+	}
+
+	return body, true, nil
+}
+
+func warnForwarded(res *http.Response, message string) {
+	l := tools.GetLogger()
+
+	l.Warn().
+		Str("request_method", res.Request.Method).
+		Str("request_url", tools.SanitizeURI(res.Request)).
+		Str("proxy_app", res.Request.Header.Get("X-Proxy-App")).
+		Str("proxy_service", res.Request.Header.Get("X-Proxy-Service")).
+		Str("content_encoding", res.Header.Get("Content-Encoding")).
+		Msg(message)
+}
+
+// forwardLarge lets a response too large to be rewritten through with the
+// service API key masked, the part already read is sent first.
+func (rw *responseRewriter) forwardLarge(res *http.Response, read []byte, rest io.ReadCloser) error {
+	warnForwarded(res, "Response too large to be rewritten, forwarded with the service API key masked")
+
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(read), rest), rest}
+
+	return rw.filterBody(res)
+}
+
+// filterBody masks the service API key in a body which is not rewritten, as
+// it reaches the client. Encoded bodies are decoded, the ones which cannot be
+// are refused.
+func (rw *responseRewriter) filterBody(res *http.Response) error {
+	if rw.scrubber == nil || rw.scrubber.key == nil || res.StatusCode < 200 || !hasBody(res) {
+		return nil
+	}
+
+	encoding := getContentEncoding(res)
+	if !isRewritableEncoding(encoding) {
+		res.Body.Close()
+		return fmt.Errorf("unsupported %s encoding", encoding)
+	}
+
+	if encoding != "" {
+		decoder, err := decodeBody(encoding, res.Body)
+		if err != nil {
+			res.Body.Close()
+			return err
+		}
+
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{decoder, res.Body}
+
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.Header.Del("ETag")
+		res.ContentLength = -1
+	}
+
+	res.Body = newKeyFilter(res.Body, rw.scrubber.key)
+
+	return nil
+}
+
+// getContentEncoding returns the encoding of a response, empty when it is
+// not encoded.
+func getContentEncoding(res *http.Response) string {
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+
+	switch encoding {
+	case "identity":
+		return ""
+	case "x-gzip":
+		return "gzip"
+	}
+
+	return encoding
+}
+
+// isRewritableEncoding tells whether a body can be decoded to be rewritten,
+// the services are asked for gzip but some answer with deflate.
+func isRewritableEncoding(encoding string) bool {
+	return encoding == "" || encoding == "gzip" || encoding == "deflate"
+}
+
+func decodeBody(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return zlib.NewReader(r)
+	}
+
+	return r, nil
+}
+
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	var encoded bytes.Buffer
+	var w io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&encoded)
+	case "deflate":
+		w = zlib.NewWriter(&encoded)
+	default:
+		return body, nil
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return encoded.Bytes(), nil
+}
+
+func (rw *responseRewriter) rewriteJSON(body []byte, transform *templates.TemplateResponse, replacer *urlReplacer) ([]byte, error) {
+	// Numbers are kept as sent, 64-bit identifiers would not survive floats.
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var document any
+	if err := dec.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	if transform != nil {
+		document = transform.Transform(document)
+	}
+
+	if replacer != nil {
+		document = rewriteStrings(document, replacer)
+	}
+
+	document = rw.scrubber.scrub(document)
+
+	var rewritten bytes.Buffer
+
+	enc := json.NewEncoder(&rewritten)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(document); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(rewritten.Bytes(), []byte("\n")), nil
+}
+
+func (rw *responseRewriter) rewriteText(body []byte, replacer *urlReplacer, apiKey string) []byte {
+	text := string(body)
+
+	if replacer != nil {
+		text = replacer.Replace(text)
+	}
+
+	if apiKey != "" {
+		text = rw.scrubber.key.replaceAll(text, url.QueryEscape(apiKey))
+	}
+
+	return []byte(rw.scrubber.scrubText(text))
+}
+
+// hasBody tells whether a response carries a body, the Content-Length of a
+// HEAD response is the one of the GET response.
+func hasBody(res *http.Response) bool {
+	return res.Request.Method != http.MethodHead &&
+		res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotModified
+}
+
+func (rw *responseRewriter) rewriteBody(res *http.Response, transform *templates.TemplateResponse, replacer *urlReplacer, apiKey string) error {
+	if !hasBody(res) {
+		return nil
+	}
+
+	// Only the service API key is looked for in the other responses.
+	kind := getResponseKind(res)
+	if res.StatusCode < 200 || res.StatusCode >= 300 || kind == responseOther {
+		return rw.filterBody(res)
+	}
+
+	// The body cannot be looked into, it is refused when the route transforms
+	// it or when it may hold the service API key.
+	encoding := getContentEncoding(res)
+	if !isRewritableEncoding(encoding) {
+		if transform != nil {
+			return fmt.Errorf("unsupported %s encoding", encoding)
+		}
+
+		if err := rw.filterBody(res); err != nil {
+			return err
+		}
+
+		warnForwarded(res, "Response encoding not supported, forwarded as is")
+		return nil
+	}
+
+	raw, complete, err := readLimited(res.Body)
+	if err != nil {
+		res.Body.Close()
+		return err
+	}
+
+	if !complete {
+		if transform != nil {
+			res.Body.Close()
+			return errResponseTooLarge
+		}
+
+		return rw.forwardLarge(res, raw, res.Body)
+	}
+
+	res.Body.Close()
+
+	body := raw
+	if encoding != "" {
+		decoder, err := decodeBody(encoding, bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+
+		body, complete, err = readLimited(decoder)
+		if err != nil {
+			return err
+		}
+
+		if !complete {
+			if transform != nil {
+				return errResponseTooLarge
+			}
+
+			return rw.forwardLarge(res, raw, http.NoBody)
+		}
+	}
+
+	var rewrite bool
+
+	switch kind {
+	case responseJSON:
+		rewrite = transform != nil || rw.scrubber.matches(body) || rw.urls.matches(body)
+		rewrite = rewrite && len(bytes.TrimSpace(body)) > 0
+	case responseText:
+		rewrite = rw.scrubber.matchesKey(body) || rw.urls.matches(body)
+	}
+
+	if rewrite {
+		if kind == responseJSON {
+			raw, err = rw.rewriteJSON(body, transform, replacer)
+			if err != nil {
+				return err
+			}
+		} else {
+			raw = rw.rewriteText(body, replacer, apiKey)
+		}
+
+		raw, err = encodeBody(encoding, raw)
+		if err != nil {
+			return err
+		}
+
+		res.Header.Del("ETag")
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(raw))
+
+	// An untouched body keeps the framing of the service.
+	if rewrite {
+		res.ContentLength = int64(len(raw))
+		res.TransferEncoding = nil
+		res.Header.Set("Content-Length", strconv.Itoa(len(raw)))
+	}
+
+	return nil
+}
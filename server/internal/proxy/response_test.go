@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const responseTemplate = `{
+	"id": "test",
+	"name": "Test",
+	"endpoints": {
+		"sonarr": {
+			"/api/v3/series": ["GET"],
+			"/api/v3/series/{id}": {
+				"methods": ["GET"],
+				"response": {"include": ["/title", "/images", "/path"], "remove": ["/images/*/remoteUrl"], "mask": ["/path"]}
+			}
+		}
+	}
+}`
+
+const upstreamETag = `"v1"`
+
+// upstreamResponse is what the test service answers with.
+type upstreamResponse struct {
+	encoding    string
+	chunked     bool
+	contentType string
+	body        string
+}
+
+func (u *upstreamResponse) serve(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := encodeBody(u.encoding, []byte(u.body))
+		if err != nil {
+			t.Error(err)
+		}
+
+		w.Header().Set("Content-Type", u.contentType)
+		w.Header().Set("ETag", upstreamETag)
+
+		if u.encoding != "" {
+			w.Header().Set("Content-Encoding", u.encoding)
+		}
+
+		if !u.chunked {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body)
+			return
+		}
+
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		w.Write(body[len(body)/2:])
+	}
+}
+
+func TestRewriteResponses(t *testing.T) {
+	upstream := &upstreamResponse{}
+	newTestProxy(t, responseTemplate, upstream.serve(t))
+
+	secret := `{"apiKey":"` + testServiceKey + `","title":"Series"}`
+	masked := `{"apiKey":"********","title":"Series"}`
+	streamMasked := `{"apiKey":"` + strings.Repeat("*", len(testServiceKey)) + `","title":"Series"}`
+	plain := `{"title":"Series"}`
+
+	series := `{"title":"T","monitored":true,"path":"/tv/T","images":[{"coverType":"poster","remoteUrl":"https://artworks.thetvdb.com/1.jpg","url":"/MediaCover/1/poster.jpg"}]}`
+	projected := `{"images":[{"coverType":"poster","url":"/MediaCover/1/poster.jpg"}],"path":"********","title":"T"}`
+
+	tests := []struct {
+		name     string
+		target   string
+		accept   string
+		upstream upstreamResponse
+		status   int
+		body     string
+		encoding string
+		etag     bool
+	}{
+		{"untouched", "/api/v3/series", "", upstreamResponse{"", false, "application/json", plain}, http.StatusOK, plain, "", true},
+		{"scrubbed", "/api/v3/series", "", upstreamResponse{"", false, "application/json", secret}, http.StatusOK, masked, "", false},
+		{"identity", "/api/v3/series", "", upstreamResponse{"identity", false, "application/json", secret}, http.StatusOK, masked, "identity", false},
+		{"gzip untouched", "/api/v3/series", "gzip", upstreamResponse{"gzip", false, "application/json", plain}, http.StatusOK, plain, "gzip", true},
+		{"gzip scrubbed", "/api/v3/series", "gzip, deflate, br", upstreamResponse{"gzip", false, "application/json", secret}, http.StatusOK, masked, "gzip", false},
+		{"deflate scrubbed", "/api/v3/series", "deflate", upstreamResponse{"deflate", false, "application/json", secret}, http.StatusOK, masked, "deflate", false},
+		{"decompressed by the transport", "/api/v3/series", "br", upstreamResponse{"", false, "application/json", secret}, http.StatusOK, masked, "", false},
+		{"chunked", "/api/v3/series", "", upstreamResponse{"", true, "application/json", secret}, http.StatusOK, masked, "", false},
+		{"chunked untouched", "/api/v3/series", "", upstreamResponse{"", true, "application/json", plain}, http.StatusOK, plain, "", true},
+		{"chunked gzip", "/api/v3/series", "gzip", upstreamResponse{"gzip", true, "application/json", secret}, http.StatusOK, masked, "gzip", false},
+		{"text", "/api/v3/series", "", upstreamResponse{"", false, "text/plain; charset=utf-8", "key " + testServiceKey}, http.StatusOK, "key " + testClientKey, "", false},
+		{"other content", "/api/v3/series", "", upstreamResponse{"", false, "application/octet-stream", secret}, http.StatusOK, streamMasked, "", true},
+		{"other content chunked", "/api/v3/series", "", upstreamResponse{"", true, "application/octet-stream", secret}, http.StatusOK, streamMasked, "", true},
+		{"other content untouched", "/api/v3/series", "", upstreamResponse{"", false, "application/octet-stream", plain}, http.StatusOK, plain, "", true},
+		{"other content gzip", "/api/v3/series", "gzip", upstreamResponse{"gzip", true, "application/octet-stream", secret}, http.StatusOK, streamMasked, "", false},
+		{"unsupported encoding", "/api/v3/series", "br", upstreamResponse{"br", false, "application/json", "opaque"}, http.StatusBadGateway, "", "", false},
+		{"projection", "/api/v3/series/1", "", upstreamResponse{"", false, "application/json", series}, http.StatusOK, projected, "", false},
+		{"projection gzip", "/api/v3/series/1", "gzip", upstreamResponse{"gzip", true, "application/json", series}, http.StatusOK, projected, "gzip", false},
+		{"projection unsupported encoding", "/api/v3/series/1", "br", upstreamResponse{"br", false, "application/json", "opaque"}, http.StatusBadGateway, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*upstream = tt.upstream
+
+			header := http.Header{apiKeyHeaderKey: {testClientKey}}
+			if tt.accept != "" {
+				header.Set("Accept-Encoding", tt.accept)
+			}
+
+			w := serve("GET", tt.target, "", header)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d (%s)", w.Code, tt.status, w.Body.String())
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding %q, want %q", got, tt.encoding)
+			}
+
+			if got := w.Header().Get("Content-Length"); got != "" && got != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("Content-Length %s, body of %d bytes", got, w.Body.Len())
+			}
+
+			// An untouched chunked body stays chunked.
+			if got := w.Header().Get("Content-Length"); tt.upstream.chunked && tt.etag && got != "" {
+				t.Errorf("Content-Length %s added to an untouched body", got)
+			}
+
+			if got := w.Header().Get("ETag") == upstreamETag; got != tt.etag {
+				t.Errorf("ETag kept %v, want %v", got, tt.etag)
+			}
+
+			body := w.Body.Bytes()
+			if tt.encoding != "br" {
+				decoder, err := decodeBody(getContentEncoding(w.Result()), bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if body, err = io.ReadAll(decoder); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !bytes.Equal(body, []byte(tt.body)) {
+				t.Errorf("body %s, want %s", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestRewriteResponsesWithoutBody(t *testing.T) {
+	newTestProxy(t, `{
+		"id": "test",
+		"name": "Test",
+		"endpoints": {
+			"sonarr": {
+				"/api/v3/series": ["GET", "HEAD"],
+				"/api/v3/series/{id}": ["GET", "PUT"]
+			}
+		}
+	}`, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == http.MethodPut:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Header.Get("If-None-Match") == upstreamETag:
+			w.Header().Set("ETag", upstreamETag)
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			// The length of the GET response, which a HEAD response has no body for.
+			w.Header().Set("ETag", upstreamETag)
+			w.Header().Set("Content-Length", "1234")
+		}
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header http.Header
+		status int
+		length string
+	}{
+		{"HEAD", "HEAD", "/api/v3/series", nil, http.StatusOK, "1234"},
+		{"no content", "PUT", "/api/v3/series/1", nil, http.StatusNoContent, ""},
+		{"not modified", "GET", "/api/v3/series", http.Header{"If-None-Match": {upstreamETag}}, http.StatusNotModified, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{apiKeyHeaderKey: {testClientKey}}
+			for k, v := range tt.header {
+				header[k] = v
+			}
+
+			w := serve(tt.method, tt.target, "", header)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d (%s)", w.Code, tt.status, w.Body.String())
+			}
+
+			if got := w.Header().Get("Content-Length"); got != tt.length {
+				t.Errorf("Content-Length %q, want %q", got, tt.length)
+			}
+
+			if w.Body.Len() > 0 {
+				t.Errorf("body %q, want none", w.Body.String())
+			}
+
+			if got := w.Header().Get("ETag"); tt.status != http.StatusNoContent && got != upstreamETag {
+				t.Errorf("ETag %q, want %q", got, upstreamETag)
+			}
+		})
+	}
+}
+
+func TestAcceptRewritableEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "gzip"},
+		{"br;q=1.0, gzip;q=0.8", "gzip"},
+		{"br", ""},
+		{"deflate", ""},
+		{"identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v3/series", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+
+			acceptRewritableEncoding(req)
+
+			if got := req.Header.Get("Accept-Encoding"); got != tt.want {
+				t.Errorf("Accept-Encoding %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetResponseKind(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        responseKind
+	}{
+		{"application/json", responseJSON},
+		{"application/json; charset=utf-8", responseJSON},
+		{"Application/JSON", responseJSON},
+		{"application/problem+json", responseJSON},
+		{"text/json", responseJSON},
+		{"application/xml", responseText},
+		{"application/rss+xml; charset=utf-8", responseText},
+		{"text/xml", responseText},
+		{"text/calendar", responseText},
+		{"text/plain", responseText},
+		{"text/html", responseOther},
+		{"image/jpeg", responseOther},
+		{"application/octet-stream", responseOther},
+		{"", responseOther},
+		{"not a media type;", responseOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			res := &http.Response{Header: http.Header{"Content-Type": {tt.contentType}}}
+
+			if got := getResponseKind(res); got != tt.want {
+				t.Errorf("getResponseKind(%q) = %d, want %d", tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type ErrorJSON struct {
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code"`
+	Details    any    `json:"details,omitempty"`
+}
+
+func errorHandler(w http.ResponseWriter, statusCode int, message string, details any) {
+	e := ErrorJSON{
+		Message:    message,
+		StatusCode: statusCode,
+		Details:    details,
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+
+	json.NewEncoder(w).Encode(e)
+}
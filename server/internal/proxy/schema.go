@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/middlewarr/server/internal/templates"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+type schemaViolation struct {
+	In   string `json:"in"`
+	Name string `json:"name,omitempty"`
+	Rule string `json:"rule"`
+}
+
+// getStrictSpecs returns the OpenAPI specs of a service type and the
+// operations of the endpoints.
+func getStrictSpecs(serviceType string, routes *templates.RouteTrie) (*tools.ServiceOpenAPISpec, map[*templates.RouteEndpoint]*tools.OpenAPIOperation, error) {
+	specs, err := tools.GetOpenAPISpecs(serviceType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	operations := make(map[*templates.RouteEndpoint]*tools.OpenAPIOperation)
+
+	for _, endpoint := range routes.Endpoints() {
+		// The paths outside of the API are not validated.
+		if !templates.IsAPIPath(endpoint.Path) {
+			continue
+		}
+
+		operation, err := specs.Operation(endpoint.OpenAPIPath(), endpoint.Method)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid OpenAPI operation %s %s: %w", endpoint.Method, endpoint.Path, err)
+		}
+
+		operations[endpoint] = operation
+	}
+
+	return specs, operations, nil
+}
+
+// getQueryValues looks a parameter up regardless of its case, as the *arr
+// services do.
+func getQueryValues(q url.Values, name string) []string {
+	var values []string
+
+	for key, v := range q {
+		if strings.EqualFold(key, name) {
+			values = append(values, v...)
+		}
+	}
+
+	return values
+}
+
+// validateSchema returns the first value of the request not matching the
+// operation, false when an error response was already written.
+func validateSchema(w http.ResponseWriter, r *http.Request, specs *tools.ServiceOpenAPISpec, endpoint *ProxyEndpoint) (*schemaViolation, bool) {
+	operation := endpoint.Operation
+
+	q := r.URL.Query()
+
+	for _, param := range operation.Parameters {
+		var values []string
+
+		switch param.In {
+		case "path":
+			if value, ok := endpoint.Params[param.Name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = getQueryValues(q, param.Name)
+		default:
+			continue
+		}
+
+		in := param.In + " parameter"
+
+		if len(values) == 0 {
+			if param.Required {
+				return &schemaViolation{in, param.Name, "is required"}, true
+			}
+
+			continue
+		}
+
+		if err := specs.ValidateParameter(param.Schema, values); err != nil {
+			return &schemaViolation{in, param.Name, err.Rule}, true
+		}
+	}
+
+	schema := operation.RequestBody.JSONSchema()
+	if schema == nil {
+		return nil, true
+	}
+
+	body, ok := bufferBody(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if operation.RequestBody.Required {
+			return &schemaViolation{"body", "", "is required"}, true
+		}
+
+		return nil, true
+	}
+
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		return &schemaViolation{"body", "", "must be valid JSON"}, true
+	}
+
+	if err := specs.ValidateSchema(schema, document, ""); err != nil {
+		return &schemaViolation{"body", err.Pointer, err.Rule}, true
+	}
+
+	return nil, true
+}
+
+// Validate Schema
+func middlewareValidateSchema(specs *tools.ServiceOpenAPISpec) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			endpoint := getRequestInfo(r).endpoint
+			if specs == nil || endpoint == nil || endpoint.Operation == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			violation, ok := validateSchema(w, r, specs, endpoint)
+			if !ok {
+				return
+			}
+
+			if violation != nil {
+				l := tools.GetLogger()
+
+				l.Warn().
+					Str("request_method", r.Method).
+					Str("request_url", tools.SanitizeURI(r)).
+					Str("proxy_app", r.Header.Get("X-Proxy-App")).
+					Str("proxy_service", r.Header.Get("X-Proxy-Service")).
+					Str("schema_in", violation.In).
+					Str("schema_name", violation.Name).
+					Str("schema_rule", violation.Rule).
+					Msg("Request does not match the OpenAPI specs")
+
+				message := strings.TrimSpace(fmt.Sprintf("Bad Request, %s %s", violation.In, violation.Name)) + " " + violation.Rule
+				errorHandler(w, http.StatusBadRequest, message, violation)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
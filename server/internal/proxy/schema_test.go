@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/middlewarr/server/internal/templates"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+func TestStrictTemplateOperations(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints string
+		strict    bool
+		loaded    bool
+	}{
+		{"strict operations in the specs", `"/api/v3/series": ["GET", "POST"]`, true, true},
+		{"strict path missing", `"/api/v3/episode": ["GET"]`, true, false},
+		{"strict method missing", `"/api/v3/series": ["DELETE"]`, true, false},
+		{"strict path outside of the API", `"/feed/v3/calendar/sonarr.ics": ["GET"]`, true, true},
+		{"lenient path missing", `"/api/v3/episode": ["GET"]`, false, true},
+		{"lenient method missing", `"/api/v3/series": ["DELETE"]`, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strict := "false"
+			if tt.strict {
+				strict = "true"
+			}
+
+			newTestProxy(t, `{"id": "test", "name": "Test", "strict": `+strict+`, "endpoints": {"sonarr": {`+tt.endpoints+`}}}`, recordPaths(new([]string)))
+
+			if _, err := templates.ReadTemplate("test"); (err == nil) != tt.loaded {
+				t.Errorf("template loaded %v, want %v", err == nil, tt.loaded)
+			}
+		})
+	}
+}
+
+const schemaSpecs = `{
+	"paths": {
+		"/api/v3/series": {
+			"get": {"parameters": [{"name": "tvdbId", "in": "query", "schema": {"type": "integer", "format": "int32"}}]},
+			"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SeriesResource"}}}}}
+		}
+	},
+	"components": {
+		"schemas": {
+			"SeriesResource": {
+				"type": "object",
+				"properties": {
+					"title": {"type": "string", "nullable": true},
+					"tvdbId": {"type": "integer", "format": "int32"}
+				}
+			}
+		}
+	}
+}`
+
+func TestMiddlewareValidateSchema(t *testing.T) {
+	var specs tools.ServiceOpenAPISpec
+	if err := json.Unmarshal([]byte(schemaSpecs), &specs); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"query", "GET", "/api/v3/series?tvdbId=78874", "", http.StatusOK},
+		{"query absent", "GET", "/api/v3/series", "", http.StatusOK},
+		{"query any case", "GET", "/api/v3/series?TvdbId=x", "", http.StatusBadRequest},
+		{"query type", "GET", "/api/v3/series?tvdbId=x", "", http.StatusBadRequest},
+		{"query int32", "GET", "/api/v3/series?tvdbId=4294967296", "", http.StatusBadRequest},
+		{"query exponent", "GET", "/api/v3/series?tvdbId=1e3", "", http.StatusBadRequest},
+		{"body", "POST", "/api/v3/series", `{"title": "Firefly", "tvdbId": 78874}`, http.StatusOK},
+		{"body nullable", "POST", "/api/v3/series", `{"title": null}`, http.StatusOK},
+		{"body empty", "POST", "/api/v3/series", "", http.StatusOK},
+		{"body type", "POST", "/api/v3/series", `{"tvdbId": "78874"}`, http.StatusBadRequest},
+		{"body not JSON", "POST", "/api/v3/series", `title=Firefly`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := newTestEndpoint(t, `["GET", "POST"]`)
+			endpoint.Method = tt.method
+
+			operation, err := specs.Operation(endpoint.Path, endpoint.Method)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			endpoint.Operation = operation
+
+			forwarded := false
+			handler := middlewareValidateSchema(&specs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = true
+			}))
+
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r = withRequestInfo(r, &requestInfo{endpoint: endpoint})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if forwarded != (tt.status == http.StatusOK) {
+				t.Errorf("forwarded %v", forwarded)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/templates"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+// Unmasking this field leaves every catalogued field visible.
+const unmaskAllFields string = "*"
+
+// The keyed hashes of the secrets only live as long as the process.
+var secretHashKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return key
+}()
+
+func hashSecret(secret string) []byte {
+	mac := hmac.New(sha256.New, secretHashKey)
+	mac.Write([]byte(secret))
+
+	return mac.Sum(nil)
+}
+
+// Length of the prefix the service API keys are looked up with.
+const serviceKeyPrefixLength = 4
+
+// serviceKey finds the API key of a service in the responses without keeping
+// it in memory: the candidates starting with its prefix are compared with a
+// keyed hash of it. The key itself is only decrypted to build the upstream
+// requests.
+type serviceKey struct {
+	prefix string
+	length int
+	hash   []byte
+}
+
+func newServiceKey(service models.Service) (*serviceKey, error) {
+	apiKey, err := tools.DecryptSecret(service.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey == "" {
+		return nil, nil
+	}
+
+	return &serviceKey{
+		prefix: apiKey[:min(serviceKeyPrefixLength, len(apiKey))],
+		length: len(apiKey),
+		hash:   hashSecret(apiKey),
+	}, nil
+}
+
+// scope tells the services apart by their key, without revealing it.
+func (k *serviceKey) scope() string {
+	if k == nil {
+		return ""
+	}
+
+	return hex.EncodeToString(k.hash[:8])
+}
+
+// index returns the offset of the key in a text, -1 when it is not found.
+func (k *serviceKey) index(text string) int {
+	if k == nil {
+		return -1
+	}
+
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], k.prefix)
+		if i < 0 {
+			return -1
+		}
+
+		start := offset + i
+		if end := start + k.length; end <= len(text) && hmac.Equal(hashSecret(text[start:end]), k.hash) {
+			return start
+		}
+
+		offset = start + 1
+	}
+}
+
+func (k *serviceKey) replaceAll(text string, replacement string) string {
+	i := k.index(text)
+	if i < 0 {
+		return text
+	}
+
+	var b strings.Builder
+
+	for ; i >= 0; i = k.index(text) {
+		b.WriteString(text[:i])
+		b.WriteString(replacement)
+		text = text[i+k.length:]
+	}
+
+	b.WriteString(text)
+
+	return b.String()
+}
+
+// keyFilter masks the service API key in a body as it is read, with a mask
+// of the same length so that the framing of the body holds. The last bytes
+// read are held back until the next ones tell whether a key starts there.
+type keyFilter struct {
+	body    io.ReadCloser
+	key     *serviceKey
+	mask    string
+	buf     []byte
+	pending []byte
+	ready   []byte
+	err     error
+}
+
+func newKeyFilter(body io.ReadCloser, key *serviceKey) io.ReadCloser {
+	if key == nil {
+		return body
+	}
+
+	return &keyFilter{
+		body: body,
+		key:  key,
+		mask: strings.Repeat("*", key.length),
+		buf:  make([]byte, 32<<10),
+	}
+}
+
+func (f *keyFilter) Read(p []byte) (int, error) {
+	for len(f.ready) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		n, err := f.body.Read(f.buf)
+		f.pending = append(f.pending, f.buf[:n]...)
+		f.err = err
+
+		f.filter(err != nil)
+	}
+
+	n := copy(p, f.ready)
+	f.ready = f.ready[n:]
+
+	return n, nil
+}
+
+// filter moves the pending bytes which cannot start a key to the ready ones,
+// all of them once the body is over.
+func (f *keyFilter) filter(final bool) {
+	text := string(f.pending)
+
+	for i := f.key.index(text); i >= 0; i = f.key.index(text) {
+		f.ready = append(f.ready, text[:i]...)
+		f.ready = append(f.ready, f.mask...)
+		text = text[i+f.key.length:]
+	}
+
+	held := 0
+	if !final {
+		held = min(len(text), f.key.length-1)
+	}
+
+	f.ready = append(f.ready, text[:len(text)-held]...)
+	f.pending = append(f.pending[:0], text[len(text)-held:]...)
+}
+
+func (f *keyFilter) Close() error {
+	return f.body.Close()
+}
+
+// secretScrubber masks the credentials held by the service in its responses,
+// the API key of the service itself is always masked.
+type secretScrubber struct {
+	fields   map[string]bool
+	key      *serviceKey
+	identity string
+}
+
+func newSecretScrubber(serviceType string, key *serviceKey, unmask []string) *secretScrubber {
+	s := &secretScrubber{
+		fields: make(map[string]bool),
+		key:    key,
+	}
+
+	unmasked := make(map[string]bool)
+	for _, field := range unmask {
+		unmasked[strings.ToLower(field)] = true
+	}
+
+	if !unmasked[unmaskAllFields] {
+		for _, field := range tools.GetSensitiveFields(serviceType) {
+			if field = strings.ToLower(field); !unmasked[field] {
+				s.fields[field] = true
+			}
+		}
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(slices.Sorted(maps.Keys(s.fields)), ",")))
+	s.identity = hex.EncodeToString(sum[:8])
+
+	return s
+}
+
+// matches tells whether a body may hold a secret, most responses are
+// forwarded without being decoded.
+func (s *secretScrubber) matches(body []byte) bool {
+	if s == nil {
+		return false
+	}
+
+	if s.matchesKey(body) {
+		return true
+	}
+
+	lower := bytes.ToLower(body)
+
+	if bytes.Contains(lower, []byte(`"privacy"`)) {
+		return true
+	}
+
+	for field := range s.fields {
+		if bytes.Contains(lower, []byte(`"`+field+`"`)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *secretScrubber) matchesKey(body []byte) bool {
+	return s != nil && s.key.index(string(body)) >= 0
+}
+
+// scrubText masks the service API key, the feeds embed it in their links.
+func (s *secretScrubber) scrubText(text string) string {
+	if s == nil {
+		return text
+	}
+
+	return s.key.replaceAll(text, templates.MaskedValue)
+}
+
+func isSecretValue(value any) bool {
+	secret, ok := value.(string)
+
+	return ok && secret != ""
+}
+
+// scrub masks the catalogued members and the settings fields of the *arr
+// providers, which are `{"name": ..., "value": ..., "privacy": ...}` objects.
+func (s *secretScrubber) scrub(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		if name, ok := v["name"].(string); ok && isSecretValue(v["value"]) {
+			privacy, _ := v["privacy"].(string)
+
+			if s.fields[strings.ToLower(name)] || privacy == "password" || privacy == "apiKey" {
+				v["value"] = templates.MaskedValue
+			}
+		}
+
+		for key, child := range v {
+			if s.fields[strings.ToLower(key)] && isSecretValue(child) {
+				v[key] = templates.MaskedValue
+				continue
+			}
+
+			v[key] = s.scrub(child)
+		}
+
+		return v
+	case []any:
+		for i, child := range v {
+			v[i] = s.scrub(child)
+		}
+
+		return v
+	case string:
+		return s.scrubText(v)
+	}
+
+	return value
+}
@@ -0,0 +1,364 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+func TestServiceKeyReplaceAll(t *testing.T) {
+	key, err := newServiceKey(models.Service{APIKey: testServiceKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"absent", "nothing to see", "nothing to see"},
+		{"whole text", testServiceKey, "XXX"},
+		{"in a link", "https://prowlarr/1/download?apikey=" + testServiceKey + "&link=a", "https://prowlarr/1/download?apikey=XXX&link=a"},
+		{"twice", testServiceKey + "/" + testServiceKey, "XXX/XXX"},
+		{"prefix only", testServiceKey[:serviceKeyPrefixLength] + "-not-the-key", testServiceKey[:serviceKeyPrefixLength] + "-not-the-key"},
+		{"truncated", testServiceKey[:len(testServiceKey)-1], testServiceKey[:len(testServiceKey)-1]},
+		{"after a false candidate", testServiceKey[:serviceKeyPrefixLength] + testServiceKey, testServiceKey[:serviceKeyPrefixLength] + "XXX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := key.replaceAll(tt.text, "XXX"); got != tt.want {
+				t.Errorf("replaceAll(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceKeyScope(t *testing.T) {
+	a, _ := newServiceKey(models.Service{APIKey: testServiceKey})
+	b, _ := newServiceKey(models.Service{APIKey: testServiceKey})
+	c, _ := newServiceKey(models.Service{APIKey: testClientKey})
+
+	if a.scope() != b.scope() {
+		t.Error("the scope of a key changed")
+	}
+
+	if a.scope() == c.scope() {
+		t.Error("two keys share a scope")
+	}
+
+	if none, _ := newServiceKey(models.Service{}); none != nil || none.scope() != "" || none.index(testServiceKey) != -1 {
+		t.Error("a service without key matches")
+	}
+}
+
+func TestSecretScrubberScrub(t *testing.T) {
+	key, err := newServiceKey(models.Service{APIKey: testServiceKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		serviceType string
+		unmask      []string
+		document    string
+		want        string
+	}{
+		{"no secret", "sonarr", nil, `{"title":"Firefly","tvdbId":78874}`, `{"title":"Firefly","tvdbId":78874}`},
+		{"catalogued field", "sonarr", nil, `{"host":"x","password":"hunter2"}`, `{"host":"x","password":"********"}`},
+		{"field any case", "sonarr", nil, `{"ApiKey":"abc"}`, `{"ApiKey":"********"}`},
+		{"empty value", "sonarr", nil, `{"password":""}`, `{"password":""}`},
+		{"null value", "sonarr", nil, `{"password":null}`, `{"password":null}`},
+		{"nested", "sonarr", nil, `[{"fields":{"token":"abc"}}]`, `[{"fields":{"token":"********"}}]`},
+		{"provider field", "sonarr", nil, `{"fields":[{"name":"apiKey","value":"abc"},{"name":"baseUrl","value":"http://x"}]}`, `{"fields":[{"name":"apiKey","value":"********"},{"name":"baseUrl","value":"http://x"}]}`},
+		{"password privacy", "sonarr", nil, `{"name":"secret","value":"abc","privacy":"password"}`, `{"name":"secret","privacy":"password","value":"********"}`},
+		{"api key privacy", "sonarr", nil, `{"name":"key","value":"abc","privacy":"apiKey"}`, `{"name":"key","privacy":"apiKey","value":"********"}`},
+		{"user name privacy", "sonarr", nil, `{"name":"username","value":"abc","privacy":"userName"}`, `{"name":"username","privacy":"userName","value":"abc"}`},
+		{"service type field", "prowlarr", nil, `{"rssKey":"abc"}`, `{"rssKey":"********"}`},
+		{"other service type field", "sonarr", nil, `{"rssKey":"abc"}`, `{"rssKey":"abc"}`},
+		{"unmasked", "sonarr", []string{"Password"}, `{"password":"hunter2","token":"abc"}`, `{"password":"hunter2","token":"********"}`},
+		{"unmasked provider field", "sonarr", []string{"apiKey"}, `{"name":"apiKey","value":"abc"}`, `{"name":"apiKey","value":"abc"}`},
+		{"unmasked privacy", "sonarr", []string{"*"}, `{"name":"key","value":"abc","privacy":"apiKey"}`, `{"name":"key","privacy":"apiKey","value":"********"}`},
+		{"all unmasked", "sonarr", []string{"*"}, `{"password":"hunter2"}`, `{"password":"hunter2"}`},
+		{"service key", "sonarr", []string{"*"}, `{"url":"http://x/feed?apikey=` + testServiceKey + `"}`, `{"url":"http://x/feed?apikey=********"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var document any
+			if err := json.Unmarshal([]byte(tt.document), &document); err != nil {
+				t.Fatal(err)
+			}
+
+			scrubber := newSecretScrubber(tt.serviceType, key, tt.unmask)
+
+			if !scrubber.matches([]byte(tt.document)) && tt.document != tt.want {
+				t.Error("a document with secrets is not matched")
+			}
+
+			got, err := json.Marshal(scrubber.scrub(document))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("scrub() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretScrubberIdentity(t *testing.T) {
+	identities := make(map[string]bool)
+
+	for _, unmask := range [][]string{nil, {"password"}, {"PASSWORD"}, {"*"}} {
+		identities[newSecretScrubber("sonarr", nil, unmask).identity] = true
+	}
+
+	// The case of the unmasked fields does not matter.
+	if len(identities) != 3 {
+		t.Errorf("%d identities, want 3", len(identities))
+	}
+
+	if newSecretScrubber("sonarr", nil, nil).identity == newSecretScrubber("prowlarr", nil, nil).identity {
+		t.Error("service types with other fields share an identity")
+	}
+}
+
+func newTestResponse(t *testing.T, endpoint *ProxyEndpoint, contentType string, body string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/api/v3/series", nil)
+	req = withRequestInfo(req, &requestInfo{endpoint: endpoint, apiKey: testClientKey})
+
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func TestRewriteLargeResponses(t *testing.T) {
+	defer func(size int64) { maxTransformedBodySize = size }(maxTransformedBodySize)
+	maxTransformedBodySize = 64
+
+	key, _ := newServiceKey(models.Service{APIKey: testServiceKey})
+	rw := &responseRewriter{scrubber: newSecretScrubber("sonarr", key, nil)}
+
+	small := `{"apiKey":"` + testServiceKey + `"}`
+	large := `{"apiKey":"x","padding":"` + strings.Repeat("a", 100) + `"}`
+	largeKey := `{"apiKey":"` + testServiceKey + `","padding":"` + strings.Repeat("a", 100) + `"}`
+	mask := strings.Repeat("*", len(testServiceKey))
+
+	tests := []struct {
+		name  string
+		route string
+		body  string
+		want  string
+		err   error
+	}{
+		{"small response scrubbed", `["GET"]`, small, `{"apiKey":"********"}`, nil},
+		{"large response forwarded", `["GET"]`, large, large, nil},
+		{"large response key masked", `["GET"]`, largeKey, strings.Replace(largeKey, testServiceKey, mask, 1), nil},
+		{"large transformed response refused", `{"methods":["GET"],"response":{"remove":["/padding"]}}`, large, "", errResponseTooLarge},
+		{"streamed response key masked", `{"methods":["GET"],"stream":true}`, small, `{"apiKey":"` + mask + `"}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newTestResponse(t, newTestEndpoint(t, tt.route), "application/json", tt.body)
+
+			err := rw.modify(res)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			got, _ := io.ReadAll(res.Body)
+			if string(got) != tt.want {
+				t.Errorf("body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	key, _ := newServiceKey(models.Service{APIKey: testServiceKey})
+	mask := strings.Repeat("*", len(testServiceKey))
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "", ""},
+		{"no key", "a body without the key", "a body without the key"},
+		{"key only", testServiceKey, mask},
+		{"key first", testServiceKey + " and more", mask + " and more"},
+		{"key last", "more and " + testServiceKey, "more and " + mask},
+		{"keys next to each other", testServiceKey + testServiceKey, mask + mask},
+		{"key after its prefix", testServiceKey[:10] + testServiceKey, testServiceKey[:10] + mask},
+		{"truncated key last", "more and " + testServiceKey[:20], "more and " + testServiceKey[:20]},
+		{"large", strings.Repeat("a", 40<<10) + testServiceKey + strings.Repeat("b", 40<<10), strings.Repeat("a", 40<<10) + mask + strings.Repeat("b", 40<<10)},
+	}
+
+	readers := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"whole", func(r io.Reader) io.Reader { return r }},
+		{"one byte", iotest.OneByteReader},
+		{"half", iotest.HalfReader},
+		{"data with EOF", iotest.DataErrReader},
+	}
+
+	for _, tt := range tests {
+		for _, reader := range readers {
+			t.Run(tt.name+"/"+reader.name, func(t *testing.T) {
+				body := io.NopCloser(reader.wrap(strings.NewReader(tt.body)))
+
+				got, err := io.ReadAll(newKeyFilter(body, key))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if string(got) != tt.want {
+					t.Errorf("body %.80q, want %.80q", got, tt.want)
+				}
+			})
+		}
+	}
+
+	// Without a key the body is left as it is.
+	body := io.NopCloser(strings.NewReader(testServiceKey))
+	if newKeyFilter(body, nil) != body {
+		t.Error("body wrapped without a key")
+	}
+}
+
+func TestFilterServiceKey(t *testing.T) {
+	defer func(size int64) { maxTransformedBodySize = size }(maxTransformedBodySize)
+	maxTransformedBodySize = 64
+
+	key, _ := newServiceKey(models.Service{APIKey: testServiceKey})
+	mask := strings.Repeat("*", len(testServiceKey))
+
+	secret := `{"apiKey":"` + testServiceKey + `"}`
+	masked := `{"apiKey":"` + mask + `"}`
+	large := `{"apiKey":"` + testServiceKey + `","padding":"` + strings.Repeat("a", 100) + `"}`
+	largeMasked := strings.Replace(large, testServiceKey, mask, 1)
+
+	stream := `{"methods":["GET"],"stream":true}`
+
+	tests := []struct {
+		name        string
+		route       string
+		method      string
+		status      int
+		contentType string
+		encoding    string
+		body        string
+		noKey       bool
+		want        string
+		length      bool
+		err         error
+	}{
+		{"stream", stream, "GET", http.StatusOK, "application/json", "", secret, false, masked, true, nil},
+		{"stream gzip", stream, "GET", http.StatusOK, "application/json", "gzip", secret, false, masked, false, nil},
+		{"stream unsupported encoding", stream, "GET", http.StatusOK, "application/json", "br", secret, false, "", false, errResponseTransform},
+		{"error status", `["GET"]`, "GET", http.StatusInternalServerError, "application/json", "", secret, false, masked, true, nil},
+		{"error status deflate", `["GET"]`, "GET", http.StatusBadRequest, "text/plain", "deflate", secret, false, masked, false, nil},
+		{"other content", `["GET"]`, "GET", http.StatusOK, "image/png", "", "PNG" + testServiceKey, false, "PNG" + mask, true, nil},
+		{"other content unsupported encoding", `["GET"]`, "GET", http.StatusOK, "image/png", "br", "opaque", false, "", false, errResponseTransform},
+		{"unsupported encoding", `["GET"]`, "GET", http.StatusOK, "application/json", "br", "opaque", false, "", false, errResponseTransform},
+		{"unsupported encoding without a key", `["GET"]`, "GET", http.StatusOK, "application/json", "br", "opaque", true, "opaque", true, nil},
+		{"too large", `["GET"]`, "GET", http.StatusOK, "application/json", "", large, false, largeMasked, true, nil},
+		{"too large gzip", `["GET"]`, "GET", http.StatusOK, "application/json", "gzip", large, false, largeMasked, false, nil},
+		{"HEAD", stream, "HEAD", http.StatusOK, "application/json", "br", "", false, "", true, nil},
+		{"no content", `["GET"]`, "GET", http.StatusNoContent, "application/json", "br", "", false, "", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := &responseRewriter{scrubber: newSecretScrubber("sonarr", key, nil)}
+			if tt.noKey {
+				rw.scrubber = newSecretScrubber("sonarr", nil, nil)
+			}
+
+			raw, err := encodeBody(tt.encoding, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := newTestResponse(t, newTestEndpoint(t, tt.route), tt.contentType, string(raw))
+			res.Request.Method = tt.method
+			res.StatusCode = tt.status
+			res.Header.Set("Content-Length", strconv.Itoa(len(raw)))
+			if tt.encoding != "" {
+				res.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			err = rw.modify(res)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			got, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("body %s, want %s", got, tt.want)
+			}
+
+			// A masked body keeps its length, a decoded one loses it.
+			if length := res.Header.Get("Content-Length") == strconv.Itoa(len(raw)); length != tt.length {
+				t.Errorf("Content-Length %q kept %v, want %v", res.Header.Get("Content-Length"), length, tt.length)
+			}
+
+			if tt.length && res.Header.Get("Content-Encoding") != tt.encoding {
+				t.Errorf("Content-Encoding %q, want %q", res.Header.Get("Content-Encoding"), tt.encoding)
+			}
+		})
+	}
+}
+
+func TestFilterServiceKeySwitchingProtocols(t *testing.T) {
+	key, _ := newServiceKey(models.Service{APIKey: testServiceKey})
+	rw := &responseRewriter{scrubber: newSecretScrubber("sonarr", key, nil)}
+
+	res := newTestResponse(t, newTestEndpoint(t, `["GET"]`), "", "")
+	res.StatusCode = http.StatusSwitchingProtocols
+
+	conn := testWebSocket{strings.NewReader(testServiceKey)}
+	res.Body = conn
+
+	if err := rw.modify(res); err != nil {
+		t.Fatal(err)
+	}
+
+	// The upgraded connection must stay writable.
+	if res.Body != io.ReadCloser(conn) {
+		t.Error("upgraded connection wrapped")
+	}
+}
@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/middlewarr/server/internal/templates"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	signalRPathPrefix        string = "/signalr/"
+	signalRRecordSeparator   byte   = 0x1e
+	signalRInvocationMessage int    = 1
+
+	// Largest WebSocket message buffered to be filtered.
+	signalRMaxMessageSize uint64 = 16 * 1024 * 1024
+
+	wsOpcodeContinuation byte = 0x0
+	wsOpcodeText         byte = 0x1
+)
+
+func isSignalRPath(path string) bool {
+	return strings.HasPrefix(strings.ToLower(path), signalRPathPrefix)
+}
+
+type signalRFilter struct {
+	messages map[string]bool
+}
+
+func newSignalRFilter(signalR *templates.TemplateSignalR) *signalRFilter {
+	if signalR == nil || len(signalR.Messages) == 0 {
+		return nil
+	}
+
+	messages := make(map[string]bool)
+	for _, m := range signalR.Messages {
+		messages[strings.ToLower(m)] = true
+	}
+
+	return &signalRFilter{messages}
+}
+
+type signalRRecord struct {
+	Type      int    `json:"type"`
+	Target    string `json:"target"`
+	Arguments []struct {
+		Name string `json:"name"`
+	} `json:"arguments"`
+}
+
+// filterRecords drops the *arr broadcast messages which are not allowed from
+// a payload of SignalR JSON records.
+func (f *signalRFilter) filterRecords(payload []byte) []byte {
+	var filtered bytes.Buffer
+
+	for _, record := range bytes.Split(payload, []byte{signalRRecordSeparator}) {
+		if len(record) == 0 {
+			continue
+		}
+
+		var message signalRRecord
+		if err := json.Unmarshal(record, &message); err == nil &&
+			message.Type == signalRInvocationMessage &&
+			len(message.Arguments) > 0 &&
+			!f.messages[strings.ToLower(message.Arguments[0].Name)] {
+			continue
+		}
+
+		filtered.Write(record)
+		filtered.WriteByte(signalRRecordSeparator)
+	}
+
+	return filtered.Bytes()
+}
+
+// signalRFilterConn wraps the upstream side of a WebSocket and filters the
+// text messages read from it.
+type signalRFilterConn struct {
+	io.ReadWriteCloser
+	filter  *signalRFilter
+	reader  *bufio.Reader
+	pending bytes.Buffer
+	message []byte
+	// inText is set while the continuation frames of a fragmented text
+	// message are expected.
+	inText bool
+}
+
+func newSignalRFilterConn(conn io.ReadWriteCloser, filter *signalRFilter) *signalRFilterConn {
+	return &signalRFilterConn{
+		ReadWriteCloser: conn,
+		filter:          filter,
+		reader:          bufio.NewReader(conn),
+	}
+}
+
+func (c *signalRFilterConn) Read(p []byte) (int, error) {
+	for c.pending.Len() == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.pending.Read(p)
+}
+
+func (c *signalRFilterConn) readFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	raw := bytes.NewBuffer(header[:])
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+
+		raw.Write(ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+
+		raw.Write(ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > signalRMaxMessageSize {
+		return errors.New("signalr message too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return err
+		}
+
+		raw.Write(mask[:])
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+
+	isText := opcode == wsOpcodeText || (opcode == wsOpcodeContinuation && c.inText)
+
+	// Control and binary frames are forwarded untouched.
+	if !isText {
+		raw.Write(payload)
+		c.pending.Write(raw.Bytes())
+		return nil
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	c.message = append(c.message, payload...)
+	if uint64(len(c.message)) > signalRMaxMessageSize {
+		return errors.New("signalr message too large")
+	}
+
+	c.inText = !fin
+	if !fin {
+		return nil
+	}
+
+	message := c.filter.filterRecords(c.message)
+	c.message = nil
+
+	if len(message) > 0 {
+		writeTextFrame(&c.pending, message)
+	}
+
+	return nil
+}
+
+func writeTextFrame(w *bytes.Buffer, payload []byte) {
+	w.WriteByte(0x80 | wsOpcodeText)
+
+	length := len(payload)
+
+	switch {
+	case length < 126:
+		w.WriteByte(byte(length))
+	case length <= 0xffff:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(length))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(length))
+	}
+
+	w.Write(payload)
+}
+
+// modifySignalRResponse applies the message filter to upgraded connections
+// and long polling responses, and hides the server-sent events transport
+// which cannot be filtered.
+func modifySignalRResponse(res *http.Response, filter *signalRFilter) error {
+	if res.StatusCode == http.StatusSwitchingProtocols {
+		conn, ok := res.Body.(io.ReadWriteCloser)
+		if !ok {
+			return errors.New("signalr upgrade without a writable body")
+		}
+
+		res.Body = newSignalRFilterConn(conn, filter)
+		return nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	isNegotiate := strings.HasSuffix(strings.ToLower(res.Request.URL.Path), "/negotiate")
+	isPoll := res.Request.Method == http.MethodGet
+
+	if !isNegotiate && !isPoll {
+		return nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if isNegotiate {
+		var negotiate map[string]any
+		if err := json.Unmarshal(body, &negotiate); err == nil {
+			if transports, ok := negotiate["availableTransports"].([]any); ok {
+				var available []any
+				for _, t := range transports {
+					if transport, ok := t.(map[string]any); ok && transport["transport"] == "ServerSentEvents" {
+						continue
+					}
+
+					available = append(available, t)
+				}
+
+				negotiate["availableTransports"] = available
+			}
+
+			if b, err := json.Marshal(negotiate); err == nil {
+				body = b
+			}
+		}
+	} else {
+		body = filter.filterRecords(body)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
+
+// SignalR
+func middlewareSignalR(signalR *templates.TemplateSignalR) Middleware {
+	filter := newSignalRFilter(signalR)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isSignalRPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if signalR == nil || !signalR.Allow {
+				http.Error(w, fmt.Sprintf("Forbidden, %s %s not allowed", r.Method, r.URL.Path), http.StatusUnauthorized)
+				return
+			}
+
+			if filter != nil {
+				if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+					http.Error(w, "Forbidden, server-sent events are not available", http.StatusForbidden)
+					return
+				}
+
+				// Compressed payloads cannot be filtered.
+				r.Header.Del("Sec-WebSocket-Extensions")
+				r.Header.Del("Accept-Encoding")
+
+				getRequestInfo(r).signalR = filter
+			}
+
+			// Hubs and long polling requests outlive the server timeouts.
+			rc := http.NewResponseController(w)
+
+			err := errors.Join(rc.SetReadDeadline(time.Time{}), rc.SetWriteDeadline(time.Time{}))
+			if err != nil && !errors.Is(err, http.ErrNotSupported) {
+				l := tools.GetLogger()
+
+				l.Warn().
+					Err(err).
+					Msg("Cannot clear the connection deadlines")
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
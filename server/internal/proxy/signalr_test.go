@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/middlewarr/server/internal/templates"
+)
+
+const (
+	wsOpcodeBinary byte = 0x2
+	wsOpcodePing   byte = 0x9
+)
+
+func signalRMessage(name string) string {
+	return `{"type":1,"target":"receiveMessage","arguments":[{"name":"` + name + `","body":{}}]}` + string(signalRRecordSeparator)
+}
+
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload string
+}
+
+func encodeFrame(f wsFrame, mask []byte) []byte {
+	var b bytes.Buffer
+
+	first := f.opcode
+	if f.fin {
+		first |= 0x80
+	}
+	b.WriteByte(first)
+
+	var masked byte
+	if mask != nil {
+		masked = 0x80
+	}
+
+	switch length := len(f.payload); {
+	case length < 126:
+		b.WriteByte(masked | byte(length))
+	case length <= 0xffff:
+		b.WriteByte(masked | 126)
+		binary.Write(&b, binary.BigEndian, uint16(length))
+	default:
+		b.WriteByte(masked | 127)
+		binary.Write(&b, binary.BigEndian, uint64(length))
+	}
+
+	payload := []byte(f.payload)
+	if mask != nil {
+		b.Write(mask)
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	b.Write(payload)
+
+	return b.Bytes()
+}
+
+func decodeFrames(t *testing.T, data []byte) []wsFrame {
+	t.Helper()
+
+	var frames []wsFrame
+	for len(data) > 0 {
+		if len(data) < 2 {
+			t.Fatalf("truncated frame header %x", data)
+		}
+
+		f := wsFrame{fin: data[0]&0x80 != 0, opcode: data[0] & 0x0f}
+		masked := data[1]&0x80 != 0
+		length := int(data[1] & 0x7f)
+		data = data[2:]
+
+		switch length {
+		case 126:
+			length = int(binary.BigEndian.Uint16(data))
+			data = data[2:]
+		case 127:
+			length = int(binary.BigEndian.Uint64(data))
+			data = data[8:]
+		}
+
+		var mask []byte
+		if masked {
+			mask, data = data[:4], data[4:]
+		}
+
+		if len(data) < length {
+			t.Fatalf("truncated frame payload, %d bytes left, want %d", len(data), length)
+		}
+
+		payload := bytes.Clone(data[:length])
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		f.payload = string(payload)
+		frames = append(frames, f)
+		data = data[length:]
+	}
+
+	return frames
+}
+
+type testWebSocket struct {
+	io.Reader
+}
+
+func (testWebSocket) Write(p []byte) (int, error) { return len(p), nil }
+func (testWebSocket) Close() error                { return nil }
+
+func TestSignalRFilterRecords(t *testing.T) {
+	filter := newSignalRFilter(&templates.TemplateSignalR{Allow: true, Messages: []string{"Series"}})
+
+	tests := []struct {
+		name     string
+		payload  string
+		filtered string
+	}{
+		{"allowed", signalRMessage("series"), signalRMessage("series")},
+		{"dropped", signalRMessage("command"), ""},
+		{"mixed", signalRMessage("command") + signalRMessage("series") + signalRMessage("queue"), signalRMessage("series")},
+		{"ping", `{"type":6}` + string(signalRRecordSeparator), `{"type":6}` + string(signalRRecordSeparator)},
+		{"not JSON", "hello" + string(signalRRecordSeparator), "hello" + string(signalRRecordSeparator)},
+		{"no arguments", `{"type":1,"target":"x","arguments":[]}` + string(signalRRecordSeparator), `{"type":1,"target":"x","arguments":[]}` + string(signalRRecordSeparator)},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if filtered := string(filter.filterRecords([]byte(tt.payload))); filtered != tt.filtered {
+				t.Errorf("filterRecords() = %q, want %q", filtered, tt.filtered)
+			}
+		})
+	}
+}
+
+func TestSignalRFilterConn(t *testing.T) {
+	filter := newSignalRFilter(&templates.TemplateSignalR{Allow: true, Messages: []string{"series"}})
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+
+	allowed := signalRMessage("series")
+	dropped := signalRMessage("command")
+	large := strings.Repeat(allowed, 200)
+
+	tests := []struct {
+		name   string
+		frames []wsFrame
+		mask   []byte
+		want   []wsFrame
+	}{
+		{
+			"single",
+			[]wsFrame{{true, wsOpcodeText, dropped + allowed}},
+			nil,
+			[]wsFrame{{true, wsOpcodeText, allowed}},
+		},
+		{
+			"dropped",
+			[]wsFrame{{true, wsOpcodeText, dropped}},
+			nil,
+			nil,
+		},
+		{
+			"extended length",
+			[]wsFrame{{true, wsOpcodeText, large + dropped}},
+			nil,
+			[]wsFrame{{true, wsOpcodeText, large}},
+		},
+		{
+			"fragmented",
+			[]wsFrame{{false, wsOpcodeText, dropped[:10]}, {false, wsOpcodeContinuation, dropped[10:] + allowed[:5]}, {true, wsOpcodeContinuation, allowed[5:]}},
+			nil,
+			[]wsFrame{{true, wsOpcodeText, allowed}},
+		},
+		{
+			"masked",
+			[]wsFrame{{false, wsOpcodeText, dropped}, {true, wsOpcodeContinuation, allowed}},
+			mask,
+			[]wsFrame{{true, wsOpcodeText, allowed}},
+		},
+		{
+			"control interleaved",
+			[]wsFrame{{false, wsOpcodeText, allowed[:10]}, {true, wsOpcodePing, "ping"}, {true, wsOpcodeContinuation, allowed[10:] + dropped}},
+			nil,
+			[]wsFrame{{true, wsOpcodePing, "ping"}, {true, wsOpcodeText, allowed}},
+		},
+		{
+			"empty first fragment",
+			[]wsFrame{{false, wsOpcodeText, ""}, {true, wsOpcodeContinuation, dropped + allowed}},
+			nil,
+			[]wsFrame{{true, wsOpcodeText, allowed}},
+		},
+		{
+			"empty fragments",
+			[]wsFrame{{false, wsOpcodeText, allowed}, {false, wsOpcodeContinuation, ""}, {true, wsOpcodeContinuation, ""}},
+			nil,
+			[]wsFrame{{true, wsOpcodeText, allowed}},
+		},
+		{
+			"binary",
+			[]wsFrame{{false, wsOpcodeBinary, dropped}, {true, wsOpcodeContinuation, dropped}},
+			nil,
+			[]wsFrame{{false, wsOpcodeBinary, dropped}, {true, wsOpcodeContinuation, dropped}},
+		},
+		{
+			"binary after text",
+			[]wsFrame{{false, wsOpcodeText, ""}, {true, wsOpcodeContinuation, allowed}, {false, wsOpcodeBinary, ""}, {true, wsOpcodeContinuation, dropped}},
+			nil,
+			[]wsFrame{{true, wsOpcodeText, allowed}, {false, wsOpcodeBinary, ""}, {true, wsOpcodeContinuation, dropped}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			for _, f := range tt.frames {
+				stream.Write(encodeFrame(f, tt.mask))
+			}
+
+			data, err := io.ReadAll(newSignalRFilterConn(testWebSocket{&stream}, filter))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+
+			frames := decodeFrames(t, data)
+			if len(frames) != len(tt.want) {
+				t.Fatalf("frames = %+v, want %+v", frames, tt.want)
+			}
+
+			for i := range frames {
+				if frames[i] != tt.want[i] {
+					t.Errorf("frame %d = %+v, want %+v", i, frames[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSignalRFilterConnTooLarge(t *testing.T) {
+	filter := newSignalRFilter(&templates.TemplateSignalR{Allow: true, Messages: []string{"series"}})
+
+	var stream bytes.Buffer
+	stream.Write([]byte{0x80 | wsOpcodeText, 127})
+	binary.Write(&stream, binary.BigEndian, signalRMaxMessageSize+1)
+
+	if _, err := io.ReadAll(newSignalRFilterConn(testWebSocket{&stream}, filter)); err == nil {
+		t.Error("read succeeded for a message over the size limit")
+	}
+}
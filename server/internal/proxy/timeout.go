@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	defaultRetryAttempts int           = 2
+	defaultRetryBackoff  time.Duration = 100 * time.Millisecond
+
+	writeDeadlineGrace time.Duration = 5 * time.Second
+)
+
+func isIdempotent(r *http.Request, endpoint *ProxyEndpoint) bool {
+	if endpoint != nil && endpoint.Route.Idempotent != nil {
+		return *endpoint.Route.Idempotent
+	}
+
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// isConnectionError reports whether the request never reached the service, or
+// the connection was dropped before any response, so it is safe to retry.
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryTransport retries idempotent requests on connection errors with an
+// exponential backoff.
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	backoff  time.Duration
+}
+
+func newRetryTransport(next http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		next:     next,
+		attempts: getSettingsInt("proxy.retries.attempts", defaultRetryAttempts),
+		backoff:  getSettingsDuration("proxy.retries.backoff", defaultRetryBackoff),
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+
+	replayable := req.Body == nil || req.Body == http.NoBody
+	if !replayable || !isIdempotent(req, getRequestInfo(req).endpoint) {
+		return res, err
+	}
+
+	for attempt := 1; attempt <= t.attempts && err != nil && isConnectionError(err); attempt++ {
+		l := tools.GetLogger()
+
+		delay := t.backoff * time.Duration(1<<(attempt-1))
+		delay += time.Duration(rand.Int64N(int64(delay)/2 + 1))
+
+		l.Debug().
+			Err(err).
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Str("request_method", req.Method).
+			Str("request_url", tools.SanitizeURI(req)).
+			Msg("Retrying request")
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		res, err = t.next.RoundTrip(req)
+	}
+
+	return res, err
+}
+
+// flushWriter sends every write to the client right away.
+type flushWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (fw *flushWriter) Write(b []byte) (int, error) {
+	n, err := fw.ResponseWriter.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	fw.rc.Flush()
+
+	return n, nil
+}
+
+func (fw *flushWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// Timeout
+func middlewareTimeout() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			endpoint := getRequestInfo(r).endpoint
+			if endpoint == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rc := http.NewResponseController(w)
+			timeout := time.Duration(endpoint.Route.Timeout)
+
+			// Replace the server-wide write timeout by the route one, streamed
+			// responses are not limited at all.
+			var err error
+
+			switch {
+			case endpoint.Route.Stream:
+				err = rc.SetWriteDeadline(time.Time{})
+				w = &flushWriter{w, rc}
+			case timeout > 0:
+				err = rc.SetWriteDeadline(time.Now().Add(timeout + writeDeadlineGrace))
+			}
+
+			if err != nil && !errors.Is(err, http.ErrNotSupported) {
+				l := tools.GetLogger()
+
+				l.Warn().
+					Err(err).
+					Msg("Cannot set the response write deadline")
+			}
+
+			if timeout > 0 {
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				defer cancel()
+
+				r = r.WithContext(ctx)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/middlewarr/server/internal/templates"
+)
+
+func newTestRouteEndpoint(idempotent *bool) *ProxyEndpoint {
+	return &ProxyEndpoint{RouteEndpoint: &templates.RouteEndpoint{Route: templates.TemplateRoute{Idempotent: idempotent}}}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		method     string
+		idempotent *bool
+		want       bool
+	}{
+		{"GET", nil, true},
+		{"HEAD", nil, true},
+		{"POST", nil, false},
+		{"PUT", nil, false},
+		{"DELETE", nil, false},
+		{"POST", &yes, true},
+		{"GET", &no, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.method, tt.idempotent != nil), func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v3/series", nil)
+
+			if got := isIdempotent(r, newTestRouteEndpoint(tt.idempotent)); got != tt.want {
+				t.Errorf("isIdempotent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !isIdempotent(httptest.NewRequest("GET", "/", nil), nil) {
+		t.Error("GET without endpoint not idempotent")
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dial", &net.OpError{Op: "dial", Err: errors.New("no route to host")}, true},
+		{"refused", fmt.Errorf("read: %w", syscall.ECONNREFUSED), true},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"closed before the response", io.EOF, true},
+		{"response cut", fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), true},
+		{"canceled", context.Canceled, false},
+		{"timeout", fmt.Errorf("round trip: %w", context.DeadlineExceeded), false},
+		{"other read error", &net.OpError{Op: "read", Err: errors.New("tls: bad record MAC")}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	yes := true
+	refused := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		idempotent *bool
+		errs       []error
+		attempts   int
+		ok         bool
+	}{
+		{"success", "GET", "", nil, nil, 1, true},
+		{"retried once", "GET", "", nil, []error{refused}, 2, true},
+		{"retries exhausted", "GET", "", nil, []error{refused, refused, refused}, 3, false},
+		{"not a connection error", "GET", "", nil, []error{context.DeadlineExceeded}, 1, false},
+		{"not idempotent", "POST", "", nil, []error{refused}, 1, false},
+		{"idempotent route", "POST", "", &yes, []error{refused}, 2, true},
+		{"body not replayable", "PUT", `{"id":1}`, &yes, []error{refused}, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+
+			transport := &retryTransport{
+				next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					attempts++
+
+					if attempts <= len(tt.errs) {
+						return nil, tt.errs[attempts-1]
+					}
+
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+				}),
+				attempts: 2,
+				backoff:  time.Millisecond,
+			}
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+
+			req := httptest.NewRequest(tt.method, "/api/v3/series", body)
+			req = withRequestInfo(req, &requestInfo{endpoint: newTestRouteEndpoint(tt.idempotent)})
+
+			_, err := transport.RoundTrip(req)
+
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+
+			if (err == nil) != tt.ok {
+				t.Errorf("error %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetryTransportCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	transport := &retryTransport{
+		next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			cancel()
+			return nil, syscall.ECONNREFUSED
+		}),
+		attempts: 2,
+		backoff:  time.Hour,
+	}
+
+	req := httptest.NewRequest("GET", "/api/v3/series", nil).WithContext(ctx)
+	req = withRequestInfo(req, &requestInfo{})
+
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	defaultDialTimeout           time.Duration = 10 * time.Second
+	defaultKeepAlive             time.Duration = 30 * time.Second
+	defaultIdleConnTimeout       time.Duration = 90 * time.Second
+	defaultTLSHandshakeTimeout   time.Duration = 10 * time.Second
+	defaultMaxIdleConns          int           = 100
+	defaultMaxIdleConnsPerHost   int           = 16
+	defaultExpectContinueTimeout time.Duration = 1 * time.Second
+)
+
+func getSettingsDuration(key string, fallback time.Duration) time.Duration {
+	s := tools.GetSettings()
+
+	if !s.Exists(key) {
+		return fallback
+	}
+
+	return s.Duration(key)
+}
+
+func getSettingsInt(key string, fallback int) int {
+	s := tools.GetSettings()
+
+	if !s.Exists(key) {
+		return fallback
+	}
+
+	return s.Int(key)
+}
+
+// newTransport returns the connection pool used to reach a service, tuned
+// from the `proxy.transport` settings.
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   getSettingsDuration("proxy.transport.dialTimeout", defaultDialTimeout),
+		KeepAlive: getSettingsDuration("proxy.transport.keepAlive", defaultKeepAlive),
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          getSettingsInt("proxy.transport.maxIdleConns", defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   getSettingsInt("proxy.transport.maxIdleConnsPerHost", defaultMaxIdleConnsPerHost),
+		IdleConnTimeout:       getSettingsDuration("proxy.transport.idleConnTimeout", defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   getSettingsDuration("proxy.transport.tlsHandshakeTimeout", defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: getSettingsDuration("proxy.transport.responseHeaderTimeout", 0),
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+	}
+}
+
+var errServiceKey = errors.New("cannot decrypt the service API key")
+
+// serviceKeyTransport authenticates the requests to the service, its API key
+// is only decrypted to be sent. A request is never forwarded without it.
+type serviceKeyTransport struct {
+	next    http.RoundTripper
+	service models.Service
+}
+
+func (t *serviceKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	apiKey, err := tools.DecryptSecret(t.service.APIKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errServiceKey, err)
+	}
+
+	req = req.Clone(req.Context())
+
+	// SignalR clients authenticate with the `access_token` query param.
+	if q := req.URL.Query(); q.Has(accessTokenQueryParamKey) {
+		q.Set(accessTokenQueryParamKey, apiKey)
+		req.URL.RawQuery = q.Encode()
+	}
+
+	// Provide the API key using the X-Api-Key header.
+	req.Header.Set(apiKeyHeaderKey, apiKey)
+
+	return t.next.RoundTrip(req)
+}
+
+func newReverseProxy(service models.Service, transport http.RoundTripper, cb *circuitBreaker, rewriter *responseRewriter) *httputil.ReverseProxy {
+	proxy := &httputil.ReverseProxy{
+		// The upstream target is chosen by the balancer transport.
+		Director: func(req *http.Request) {
+			// Remove the `apikey` query param, the service key is set by
+			// serviceKeyTransport.
+			q := req.URL.Query()
+			q.Del(apiKeyQueryParamKey)
+			req.URL.RawQuery = q.Encode()
+
+			// The client key must not reach the service.
+			req.Header.Del(apiKeyHeaderKey)
+
+			if _, ok := req.Header["User-Agent"]; !ok {
+				req.Header.Set("User-Agent", "")
+			}
+
+			// Responses are rewritten whole, ranges of them cannot be.
+			if endpoint := getRequestInfo(req).endpoint; endpoint != nil && endpoint.Route.Response != nil {
+				req.Header.Del("Range")
+			}
+
+			acceptRewritableEncoding(req)
+		},
+		Transport: &serviceKeyTransport{transport, service},
+	}
+
+	proxy.ModifyResponse = func(res *http.Response) error {
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			cb.recordFailure(fmt.Errorf("upstream responded %s", res.Status))
+		default:
+			cb.recordSuccess()
+		}
+
+		info := getRequestInfo(res.Request)
+
+		if info.signalR != nil {
+			return modifySignalRResponse(res, info.signalR)
+		}
+
+		setImageCacheControl(res, info.endpoint)
+
+		return rewriter.modify(res)
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		l := tools.GetLogger()
+
+		// The client went away, the service is not to blame.
+		if errors.Is(err, context.Canceled) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		if errors.Is(err, errServiceKey) {
+			l.Error().
+				Err(err).
+				Str("proxy_service", service.Name).
+				Str("proxy_type", service.Type).
+				Str("request_method", r.Method).
+				Str("request_url", tools.SanitizeURI(r)).
+				Msg("Cannot decrypt the service API key")
+
+			errorHandler(w, http.StatusBadGateway, "Bad Gateway, cannot authenticate to the service", nil)
+			return
+		}
+
+		// The service answered, its response could not be rewritten.
+		if errors.Is(err, errResponseTransform) {
+			l.Error().
+				Err(err).
+				Str("proxy_service", service.Name).
+				Str("proxy_type", service.Type).
+				Str("request_method", r.Method).
+				Str("request_url", tools.SanitizeURI(r)).
+				Msg("Cannot rewrite the response")
+
+			errorHandler(w, http.StatusBadGateway, "Bad Gateway, cannot rewrite the response", nil)
+			return
+		}
+
+		cb.recordFailure(err)
+
+		l.Warn().
+			Err(err).
+			Str("proxy_service", service.Name).
+			Str("proxy_type", service.Type).
+			Str("request_method", r.Method).
+			Str("request_url", tools.SanitizeURI(r)).
+			Msg("Cannot reach the service")
+
+		statusCode := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			statusCode = http.StatusGatewayTimeout
+		}
+
+		message := fmt.Sprintf("%s, cannot reach %s", http.StatusText(statusCode), service.Name)
+		errorHandler(w, statusCode, message, nil)
+	}
+
+	return proxy
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewTransport(t *testing.T) {
+	defaults := newTransport()
+
+	if defaults.IdleConnTimeout != defaultIdleConnTimeout || defaults.TLSHandshakeTimeout != defaultTLSHandshakeTimeout ||
+		defaults.MaxIdleConns != defaultMaxIdleConns || defaults.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost ||
+		defaults.ResponseHeaderTimeout != 0 {
+		t.Errorf("default transport %+v", defaults)
+	}
+
+	s := tools.GetSettings()
+
+	settings := map[string]any{
+		"proxy.transport.idleConnTimeout":       "45s",
+		"proxy.transport.tlsHandshakeTimeout":   "5s",
+		"proxy.transport.responseHeaderTimeout": "1m",
+		"proxy.transport.maxIdleConns":          10,
+		"proxy.transport.maxIdleConnsPerHost":   2,
+	}
+
+	for key, value := range settings {
+		s.Set(key, value)
+		t.Cleanup(func() { s.Delete(key) })
+	}
+
+	transport := newTransport()
+
+	tests := []struct {
+		field string
+		got   any
+		want  any
+	}{
+		{"IdleConnTimeout", transport.IdleConnTimeout, 45 * time.Second},
+		{"TLSHandshakeTimeout", transport.TLSHandshakeTimeout, 5 * time.Second},
+		{"ResponseHeaderTimeout", transport.ResponseHeaderTimeout, time.Minute},
+		{"MaxIdleConns", transport.MaxIdleConns, 10},
+		{"MaxIdleConnsPerHost", transport.MaxIdleConnsPerHost, 2},
+		{"ExpectContinueTimeout", transport.ExpectContinueTimeout, defaultExpectContinueTimeout},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+}
+
+func TestServiceKeyTransport(t *testing.T) {
+	encrypted, err := tools.EncryptSecret(testServiceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		apiKey      string
+		target      string
+		header      string
+		accessToken string
+		err         bool
+	}{
+		{"encrypted key", encrypted, "/api/v3/series", testServiceKey, "", false},
+		{"plaintext key", testServiceKey, "/api/v3/series", testServiceKey, "", false},
+		{"signalr access token", encrypted, "/signalr/messages/negotiate?access_token=" + testClientKey, testServiceKey, testServiceKey, false},
+		{"unknown master key", "enc:v1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", "/api/v3/series", "", "", true},
+		{"invalid ciphertext", "enc:v1:%%%", "/api/v3/series", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent *http.Request
+
+			transport := &serviceKeyTransport{
+				next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					sent = r
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+				}),
+				service: models.Service{Name: "sonarr", APIKey: tt.apiKey},
+			}
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.Header.Set(apiKeyHeaderKey, testClientKey)
+
+			_, err := transport.RoundTrip(req)
+
+			if tt.err {
+				if !errors.Is(err, errServiceKey) {
+					t.Fatalf("error %v, want %v", err, errServiceKey)
+				}
+
+				if sent != nil {
+					t.Fatal("request forwarded without the service key")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := sent.Header.Get(apiKeyHeaderKey); got != tt.header {
+				t.Errorf("X-Api-Key %q, want %q", got, tt.header)
+			}
+
+			if got := sent.URL.Query().Get(accessTokenQueryParamKey); got != tt.accessToken {
+				t.Errorf("access_token %q, want %q", got, tt.accessToken)
+			}
+
+			if req.Header.Get(apiKeyHeaderKey) != testClientKey {
+				t.Error("the request of the caller was modified")
+			}
+		})
+	}
+}
+
+func TestServiceKeyDecryptionFailure(t *testing.T) {
+	service := models.Service{Name: "sonarr", Type: "sonarr", APIKey: "enc:v1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}
+
+	if _, err := newServiceKey(service); err == nil {
+		t.Error("proxy built with an undecryptable service key")
+	}
+
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("request forwarded as %s %s", r.Method, r.URL)
+		return nil, errors.New("unreachable")
+	})
+
+	proxy := newReverseProxy(service, next, getCircuitBreaker(service), &responseRewriter{})
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "http://middlewarr/api/v3/series", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+// urlRewriter replaces the addresses of the service targets with the public
+// address of middlewarr in the responses.
+type urlRewriter struct {
+	upstreams []string
+	public    string
+}
+
+func newURLRewriter(service models.Service) *urlRewriter {
+	l := tools.GetLogger()
+	s := tools.GetSettings()
+
+	public := strings.TrimSuffix(s.String("publicUrl"), "/")
+	if public == "" {
+		l.Warn().
+			Str("proxy_service", service.Name).
+			Msg("Missing publicUrl setting, URLs will not be rewritten")
+
+		return nil
+	}
+
+	u := &urlRewriter{public: public}
+
+	for _, target := range getServiceTargets(service) {
+		u.upstreams = append(u.upstreams, strings.TrimSuffix(target.URL, "/"))
+	}
+
+	// A target may be the prefix of another, the longest is replaced first.
+	slices.SortFunc(u.upstreams, func(a, b string) int {
+		return len(b) - len(a)
+	})
+
+	return u
+}
+
+// urlReplacer replaces the addresses of the service targets. An address is
+// only replaced when it is not followed by more of a host name or a port,
+// http://sonarr must not turn http://sonarr2 or http://sonarr:9999 into
+// addresses of middlewarr.
+type urlReplacer struct {
+	upstreams []string
+	// The longest addresses come first, they are tried in order.
+	pairs [][2]string
+}
+
+// replacer returns the replacements for a request, the API of a service
+// reached through its path prefix stays behind that prefix.
+func (u *urlRewriter) replacer(servicePrefix string) *urlReplacer {
+	r := &urlReplacer{upstreams: u.upstreams}
+
+	for _, upstream := range u.upstreams {
+		if servicePrefix != "" {
+			r.pairs = append(r.pairs, [2]string{upstream + "/api/", u.public + servicePrefix + "/"})
+		}
+
+		r.pairs = append(r.pairs, [2]string{upstream, u.public})
+	}
+
+	return r
+}
+
+func isHostByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '.' || c == '-' || c == '_' || c == ':'
+}
+
+// replaceAt returns the replacement of the address starting the text and the
+// length it replaces, 0 when there is none.
+func (r *urlReplacer) replaceAt(text string) (string, int) {
+	for _, pair := range r.pairs {
+		old := pair[0]
+		if !strings.HasPrefix(text, old) {
+			continue
+		}
+
+		if !strings.HasSuffix(old, "/") && len(text) > len(old) && isHostByte(text[len(old)]) {
+			continue
+		}
+
+		return pair[1], len(old)
+	}
+
+	return "", 0
+}
+
+func (r *urlReplacer) Replace(text string) string {
+	var b strings.Builder
+
+	offset := 0
+	replaced := false
+
+	for {
+		// The next position any upstream address starts at.
+		next := -1
+		for _, upstream := range r.upstreams {
+			if i := strings.Index(text[offset:], upstream); i >= 0 && (next < 0 || offset+i < next) {
+				next = offset + i
+			}
+		}
+
+		if next < 0 {
+			break
+		}
+
+		replacement, n := r.replaceAt(text[next:])
+		if n == 0 {
+			b.WriteString(text[offset : next+1])
+			offset = next + 1
+			continue
+		}
+
+		b.WriteString(text[offset:next])
+		b.WriteString(replacement)
+		offset = next + n
+		replaced = true
+	}
+
+	if !replaced {
+		return text
+	}
+
+	b.WriteString(text[offset:])
+
+	return b.String()
+}
+
+func (u *urlRewriter) matches(body []byte) bool {
+	if u == nil {
+		return false
+	}
+
+	for _, upstream := range u.upstreams {
+		if bytes.Contains(body, []byte(upstream)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewriteStrings replaces the addresses in every string of a document.
+func rewriteStrings(value any, replacer *urlReplacer) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			v[key] = rewriteStrings(child, replacer)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = rewriteStrings(child, replacer)
+		}
+	case string:
+		return replacer.Replace(v)
+	}
+
+	return value
+}
+
+func rewriteLocation(res *http.Response, replacer *urlReplacer) {
+	if location := res.Header.Get("Location"); location != "" {
+		res.Header.Set("Location", replacer.Replace(location))
+	}
+}
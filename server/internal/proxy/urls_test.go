@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func newTestURLRewriter(upstreams ...string) *urlRewriter {
+	return &urlRewriter{upstreams: upstreams, public: "https://middlewarr.example.com"}
+}
+
+func TestURLReplacer(t *testing.T) {
+	// The longest target comes first, as sorted by newURLRewriter.
+	urls := newTestURLRewriter("http://10.0.0.2:8989/sonarr", "http://sonarr:8989", "http://10.0.0.2:8989")
+
+	tests := []struct {
+		name          string
+		servicePrefix string
+		text          string
+		want          string
+	}{
+		{"none", "", "nothing to see", "nothing to see"},
+		{"address", "", "http://sonarr:8989", "https://middlewarr.example.com"},
+		{"link", "", `{"url":"http://sonarr:8989/api/v3/series?page=1"}`, `{"url":"https://middlewarr.example.com/api/v3/series?page=1"}`},
+		{"several", "", "http://sonarr:8989/a http://10.0.0.2:8989/b", "https://middlewarr.example.com/a https://middlewarr.example.com/b"},
+		{"longest target", "", "http://10.0.0.2:8989/sonarr/feed", "https://middlewarr.example.com/feed"},
+		{"target path boundary", "", "http://10.0.0.2:8989/sonarr2/feed", "https://middlewarr.example.com/sonarr2/feed"},
+		{"other port", "", "http://sonarr:89890/a", "http://sonarr:89890/a"},
+		{"other host", "", "http://sonarr:8989.example.com/a", "http://sonarr:8989.example.com/a"},
+		{"query", "", "http://sonarr:8989?a=1", "https://middlewarr.example.com?a=1"},
+		{"fragment", "", "<a href=\"http://sonarr:8989#top\">", "<a href=\"https://middlewarr.example.com#top\">"},
+		{"candidate before a match", "", "http://sonarr:89890 http://sonarr:8989/a", "http://sonarr:89890 https://middlewarr.example.com/a"},
+		{"service prefix", "/api/svc/sonarr", "http://sonarr:8989/api/v3/series", "https://middlewarr.example.com/api/svc/sonarr/v3/series"},
+		{"service prefix outside of the API", "/api/svc/sonarr", "http://sonarr:8989/feed/v3/calendar", "https://middlewarr.example.com/feed/v3/calendar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := urls.replacer(tt.servicePrefix).Replace(tt.text); got != tt.want {
+				t.Errorf("Replace(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLRewriterMatches(t *testing.T) {
+	urls := newTestURLRewriter("http://sonarr:8989")
+
+	if !urls.matches([]byte(`{"url":"http://sonarr:8989/a"}`)) {
+		t.Error("a body with the service address is not matched")
+	}
+
+	if urls.matches([]byte(`{"url":"https://example.org/"}`)) {
+		t.Error("a body without the service address is matched")
+	}
+
+	var none *urlRewriter
+	if none.matches([]byte("http://sonarr:8989")) {
+		t.Error("a missing rewriter matches")
+	}
+}
+
+func TestRewriteStrings(t *testing.T) {
+	replacer := newTestURLRewriter("http://sonarr:8989").replacer("")
+
+	document := map[string]any{
+		"url":    "http://sonarr:8989/a",
+		"links":  []any{"http://sonarr:8989/b", 1.0},
+		"nested": map[string]any{"url": "http://sonarr:8989"},
+	}
+
+	rewriteStrings(document, replacer)
+
+	if document["url"] != "https://middlewarr.example.com/a" ||
+		document["links"].([]any)[0] != "https://middlewarr.example.com/b" || document["links"].([]any)[1] != 1.0 ||
+		document["nested"].(map[string]any)["url"] != "https://middlewarr.example.com" {
+		t.Errorf("rewriteStrings() = %v", document)
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	replacer := newTestURLRewriter("http://sonarr:8989").replacer("/api/svc/sonarr")
+
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"", ""},
+		{"/login", "/login"},
+		{"http://sonarr:8989/login", "https://middlewarr.example.com/login"},
+		{"http://sonarr:8989/api/v3/series/1", "https://middlewarr.example.com/api/svc/sonarr/v3/series/1"},
+		{"https://example.org/", "https://example.org/"},
+	}
+
+	for _, tt := range tests {
+		res := &http.Response{Header: http.Header{}}
+		if tt.location != "" {
+			res.Header.Set("Location", tt.location)
+		}
+
+		rewriteLocation(res, replacer)
+
+		if got := res.Header.Get("Location"); got != tt.want {
+			t.Errorf("Location %q rewritten to %q, want %q", tt.location, got, tt.want)
+		}
+	}
+}
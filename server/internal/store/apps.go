@@ -5,29 +5,79 @@ import (
 	"strings"
 
 	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
 	"gorm.io/gorm"
 )
 
+func validateApp(app *models.App) error {
+	err := tools.ValidateAuthSchemes(app.AuthSchemes)
+	if err != nil {
+		return err
+	}
+
+	err = tools.ValidateCIDRs(app.AllowedCIDRs)
+	if err != nil {
+		return err
+	}
+
+	err = tools.ValidateCIDRs(app.DeniedCIDRs)
+	if err != nil {
+		return err
+	}
+
+	return tools.ValidateAppSchedule(app.Schedule)
+}
+
 func (c *ConfigurationRepository) CreateApp(app *models.App) error {
 	// TODO: Validate app template
 
-	err := gorm.G[models.App](c.db).Create(c.ctx, app)
+	err := validateApp(app)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), errUniqueConstraintFailed.Error()) {
-			return errors.New("an app with the same name already exists")
-		}
-
 		return err
 	}
 
+	c.hashAppKey(app)
+
+	err = gorm.G[models.App](c.db).Create(c.ctx, app)
+	if err != nil {
+		return appConstraintError(err)
+	}
+
 	return nil
 }
 
+// hashAppKey replaces the app-level key given by the caller by its hash.
+func (c *ConfigurationRepository) hashAppKey(app *models.App) {
+	if app.APIKey == nil || *app.APIKey == "" {
+		return
+	}
+
+	apiKeyHash := c.HashApiKey(*app.APIKey)
+	apiKeyPrefix := getApiKeyPrefix(*app.APIKey)
+
+	app.APIKeyHash = &apiKeyHash
+	app.APIKeyPrefix = &apiKeyPrefix
+}
+
+func appConstraintError(err error) error {
+	if strings.HasPrefix(err.Error(), errUniqueConstraintFailed.Error()) {
+		if strings.Contains(err.Error(), "api_key_hash") {
+			return errors.New("an app with the same API key already exists")
+		}
+
+		return errors.New("an app with the same name already exists")
+	}
+
+	return err
+}
+
 func (c *ConfigurationRepository) ReadApp(id int) (*models.App, error) {
 	app, err := gorm.G[models.App](c.db).
 		Preload("Proxies", nil).
 		Preload("Proxies.App", nil).
+		Preload("Proxies.Credentials", nil).
 		Preload("Proxies.Service", nil).
+		Preload("Proxies.Service.Targets", nil).
 		Where("id = ?", id).
 		First(c.ctx)
 	if err != nil {
@@ -41,7 +91,9 @@ func (c *ConfigurationRepository) ReadApps() (*[]models.App, error) {
 	apps, err := gorm.G[models.App](c.db).
 		Preload("Proxies", nil).
 		Preload("Proxies.App", nil).
+		Preload("Proxies.Credentials", nil).
 		Preload("Proxies.Service", nil).
+		Preload("Proxies.Service.Targets", nil).
 		Find(c.ctx)
 	if err != nil {
 		return nil, err
@@ -53,11 +105,56 @@ func (c *ConfigurationRepository) ReadApps() (*[]models.App, error) {
 func (c *ConfigurationRepository) UpdateApp(id int, app *models.App) error {
 	// TODO: Validate fields
 
-	_, err := gorm.G[models.App](c.db).Where("id = ?", id).Updates(c.ctx, *app)
+	err := validateApp(app)
 	if err != nil {
 		return err
 	}
 
+	c.hashAppKey(app)
+
+	_, err = gorm.G[models.App](c.db).Where("id = ?", id).Updates(c.ctx, *app)
+	if err != nil {
+		return appConstraintError(err)
+	}
+
+	return nil
+}
+
+// CreateAppKey generates a new app-level API key, replacing the previous one.
+func (c *ConfigurationRepository) CreateAppKey(id int) (string, error) {
+	apiKey := generateApiKey()
+
+	app := models.App{APIKey: &apiKey}
+	c.hashAppKey(&app)
+
+	rows, err := gorm.G[models.App](c.db).
+		Select("APIKeyHash", "APIKeyPrefix").
+		Where("id = ?", id).
+		Updates(c.ctx, app)
+	if err != nil {
+		return "", appConstraintError(err)
+	}
+
+	if rows == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+
+	return apiKey, nil
+}
+
+func (c *ConfigurationRepository) DestroyAppKey(id int) error {
+	rows, err := gorm.G[models.App](c.db).
+		Select("APIKeyHash", "APIKeyPrefix").
+		Where("id = ?", id).
+		Updates(c.ctx, models.App{})
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
 	return nil
 }
 
@@ -11,8 +11,9 @@ import (
 )
 
 type ConfigurationRepository struct {
-	db  *gorm.DB
-	ctx context.Context
+	db   *gorm.DB
+	ctx  context.Context
+	salt string
 }
 
 const (
@@ -41,6 +42,12 @@ func NewConfigurationRepository() *ConfigurationRepository {
 			Msg("failed to migrate Services")
 	}
 
+	if err := db.AutoMigrate(&models.ServiceTarget{}); err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to migrate Service targets")
+	}
+
 	if err := db.AutoMigrate(&models.App{}); err != nil {
 		l.Panic().
 			Err(err).
@@ -53,13 +60,56 @@ func NewConfigurationRepository() *ConfigurationRepository {
 			Msg("failed to migrate Proxies")
 	}
 
+	if err := db.AutoMigrate(&models.ProxyCredential{}); err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to migrate Proxy credentials")
+	}
+
+	if err := db.AutoMigrate(&models.ProxyUsage{}); err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to migrate Proxy usages")
+	}
+
 	if err := db.AutoMigrate(&models.Notification{}); err != nil {
 		l.Panic().
 			Err(err).
 			Msg("failed to migrate Notifications")
 	}
 
-	c := &ConfigurationRepository{db, ctx}
+	if err := db.AutoMigrate(&models.Setting{}); err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to migrate Settings")
+	}
+
+	salt, err := loadApiKeySalt(db)
+	if err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to load API key salt")
+	}
+
+	if err := migrateApiKeys(db, salt); err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to migrate API keys")
+	}
+
+	if err := dropServiceKeyIndex(db); err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to migrate Service API keys")
+	}
+
+	if _, err := reencryptServiceKeys(db, false); err != nil {
+		l.Panic().
+			Err(err).
+			Msg("failed to encrypt Service API keys")
+	}
+
+	c := &ConfigurationRepository{db, ctx, salt}
 
 	return c
 }
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/middlewarr/server/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	apiKeySaltSetting  string = "apiKeySalt"
+	apiKeyPrefixLength int    = 7
+)
+
+// HashApiKey returns the salted hash under which an API key is stored.
+func (c *ConfigurationRepository) HashApiKey(apiKey string) string {
+	return hashApiKey(c.salt, apiKey)
+}
+
+func hashApiKey(salt string, apiKey string) string {
+	sum := sha256.Sum256([]byte(salt + apiKey))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// getApiKeyPrefix returns the visible part of a key, short keys show nothing.
+func getApiKeyPrefix(apiKey string) string {
+	if len(apiKey) < 16 {
+		return ""
+	}
+
+	return apiKey[:apiKeyPrefixLength]
+}
+
+func loadApiKeySalt(db *gorm.DB) (string, error) {
+	var setting models.Setting
+
+	err := db.Where("key = ?", apiKeySaltSetting).First(&setting).Error
+	if err == nil {
+		return setting.Value, nil
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	setting = models.Setting{
+		Key:   apiKeySaltSetting,
+		Value: hex.EncodeToString(salt),
+	}
+
+	if err := db.Create(&setting).Error; err != nil {
+		return "", err
+	}
+
+	return setting.Value, nil
+}
+
+// migrateApiKeys replaces the plaintext `api_key` column of the apps, proxies
+// and proxy credentials by the salted hash and the prefix of the key.
+func migrateApiKeys(db *gorm.DB, salt string) error {
+	for _, model := range []any{&models.App{}, &models.Proxy{}, &models.ProxyCredential{}} {
+		if !db.Migrator().HasColumn(model, "api_key") {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var rows []struct {
+				ID     uint
+				APIKey *string
+			}
+
+			err := tx.Model(model).Select("id", "api_key").Find(&rows).Error
+			if err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				if row.APIKey == nil || *row.APIKey == "" {
+					continue
+				}
+
+				err = tx.Model(model).Where("id = ?", row.ID).UpdateColumns(map[string]any{
+					"api_key_hash":   hashApiKey(salt, *row.APIKey),
+					"api_key_prefix": getApiKeyPrefix(*row.APIKey),
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			stmt := &gorm.Statement{DB: tx}
+			if err := stmt.Parse(model); err != nil {
+				return err
+			}
+
+			index := "idx_" + stmt.Schema.Table + "_api_key"
+			if tx.Migrator().HasIndex(model, index) {
+				if err := tx.Migrator().DropIndex(model, index); err != nil {
+					return err
+				}
+			}
+
+			// Native drop, rebuilding the table would break the foreign keys.
+			return tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: stmt.Schema.Table}, clause.Column{Name: "api_key"}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
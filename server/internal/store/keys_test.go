@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/middlewarr/server/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testKeyRow struct {
+	ID           uint
+	APIKeyHash   *string
+	APIKeyPrefix *string
+}
+
+// openTestDB opens an empty database with the tables of the models.
+func openTestDB(t *testing.T, models ...any) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "middlewarr.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newLegacyKeysDB opens a database where the apps, proxies and proxy
+// credentials still store their API key in plaintext.
+func newLegacyKeysDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := openTestDB(t, &models.Service{}, &models.App{}, &models.Proxy{}, &models.ProxyCredential{})
+
+	statements := []string{
+		"ALTER TABLE apps ADD COLUMN api_key text",
+		"ALTER TABLE proxies ADD COLUMN api_key text",
+		"ALTER TABLE proxy_credentials ADD COLUMN api_key text",
+		"CREATE UNIQUE INDEX idx_proxies_api_key ON proxies(api_key)",
+		"INSERT INTO services (id, type, name, url) VALUES (1, 'sonarr', 'sonarr', 'http://sonarr:8989'), (2, 'radarr', 'radarr', 'http://radarr:7878')",
+		"INSERT INTO apps (id, name, api_key) VALUES (1, 'with key', 'app0123456789abcdef0123456789abcd'), (2, 'without key', NULL)",
+		"INSERT INTO proxies (id, app_id, service_id, api_key) VALUES (1, 1, 1, 'proxy0123456789abcdef0123456789ab'), (2, 1, 2, 'short')",
+		"INSERT INTO proxy_credentials (id, proxy_id, api_key) VALUES (1, 1, 'previous0123456789abcdef012345678')",
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	return db
+}
+
+func readKeyRows(t *testing.T, db *gorm.DB, table string) []testKeyRow {
+	t.Helper()
+
+	var rows []testKeyRow
+	if err := db.Table(table).Select("id", "api_key_hash", "api_key_prefix").Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return rows
+}
+
+func TestMigrateApiKeys(t *testing.T) {
+	db := newLegacyKeysDB(t)
+	c := &ConfigurationRepository{db, context.Background(), "salt"}
+
+	if err := migrateApiKeys(db, c.salt); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, model := range []any{&models.App{}, &models.Proxy{}, &models.ProxyCredential{}} {
+		if db.Migrator().HasColumn(model, "api_key") {
+			t.Errorf("%T still has the api_key column", model)
+		}
+	}
+
+	if db.Migrator().HasIndex(&models.Proxy{}, "idx_proxies_api_key") {
+		t.Error("api_key index not dropped")
+	}
+
+	tests := []struct {
+		table  string
+		id     uint
+		apiKey string
+		prefix string
+	}{
+		{"apps", 1, "app0123456789abcdef0123456789abcd", "app0123"},
+		{"proxies", 1, "proxy0123456789abcdef0123456789ab", "proxy01"},
+		{"proxies", 2, "short", ""},
+		{"proxy_credentials", 1, "previous0123456789abcdef012345678", "previou"},
+	}
+
+	for _, tt := range tests {
+		var row testKeyRow
+		if err := db.Table(tt.table).Where("api_key_hash = ?", c.HashApiKey(tt.apiKey)).First(&row).Error; err != nil {
+			t.Errorf("%s %q not found by hash: %v", tt.table, tt.apiKey, err)
+			continue
+		}
+
+		if row.ID != tt.id || row.APIKeyPrefix == nil || *row.APIKeyPrefix != tt.prefix {
+			t.Errorf("%s %q = %+v, want id %d and prefix %q", tt.table, tt.apiKey, row, tt.id, tt.prefix)
+		}
+	}
+
+	// The app without a key keeps no hash.
+	if apps := readKeyRows(t, db, "apps"); apps[1].APIKeyHash != nil {
+		t.Errorf("app without a key hashed to %q", *apps[1].APIKeyHash)
+	}
+
+	// A second run finds nothing left to migrate.
+	before := map[string][]testKeyRow{}
+	for _, table := range []string{"apps", "proxies", "proxy_credentials"} {
+		before[table] = readKeyRows(t, db, table)
+	}
+
+	if err := migrateApiKeys(db, c.salt); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	for table, rows := range before {
+		if after := readKeyRows(t, db, table); !reflect.DeepEqual(after, rows) {
+			t.Errorf("second run changed %s", table)
+		}
+	}
+}
+
+func TestHashApiKey(t *testing.T) {
+	c := &ConfigurationRepository{salt: "salt"}
+	other := &ConfigurationRepository{salt: "pepper"}
+
+	if c.HashApiKey("key") != c.HashApiKey("key") {
+		t.Error("hash not stable")
+	}
+
+	if c.HashApiKey("key") == c.HashApiKey("Key") {
+		t.Error("keys in another case share a hash")
+	}
+
+	if c.HashApiKey("key") == other.HashApiKey("key") {
+		t.Error("hash ignores the salt")
+	}
+
+	if c.HashApiKey("key") == "key" || len(c.HashApiKey("key")) != 64 {
+		t.Errorf("hash %q is not a SHA-256 hex digest", c.HashApiKey("key"))
+	}
+}
@@ -3,9 +3,11 @@ package store
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
 	"gorm.io/gorm"
 )
 
@@ -15,12 +17,33 @@ func generateApiKey() string {
 	return apiKey
 }
 
+func validateProxy(proxy *models.Proxy) error {
+	err := tools.ValidateCIDRs(proxy.AllowedCIDRs)
+	if err != nil {
+		return err
+	}
+
+	return tools.ValidateCIDRs(proxy.DeniedCIDRs)
+}
+
 func (c *ConfigurationRepository) CreateProxy(proxy *models.Proxy) error {
+	err := validateProxy(proxy)
+	if err != nil {
+		return err
+	}
+
 	if proxy.APIKey == "" {
 		proxy.APIKey = generateApiKey()
 	}
 
-	err := gorm.G[models.Proxy](c.db).Create(c.ctx, proxy)
+	// Only the hash is stored, the key is returned once to the caller.
+	proxy.APIKeyHash = c.HashApiKey(proxy.APIKey)
+	proxy.APIKeyPrefix = getApiKeyPrefix(proxy.APIKey)
+
+	// Additional credentials are only issued by a rotation.
+	proxy.Credentials = nil
+
+	err = gorm.G[models.Proxy](c.db).Create(c.ctx, proxy)
 	if err != nil {
 		if strings.HasPrefix(err.Error(), errUniqueConstraintFailed.Error()) {
 			return errors.New("a proxy for the selected app and service already exists")
@@ -35,7 +58,9 @@ func (c *ConfigurationRepository) CreateProxy(proxy *models.Proxy) error {
 func (c *ConfigurationRepository) ReadProxy(id int) (*models.Proxy, error) {
 	proxy, err := gorm.G[models.Proxy](c.db).
 		Preload("App", nil).
+		Preload("Credentials", nil).
 		Preload("Service", nil).
+		Preload("Service.Targets", nil).
 		Where("id = ?", id).
 		First(c.ctx)
 	if err != nil {
@@ -48,7 +73,9 @@ func (c *ConfigurationRepository) ReadProxy(id int) (*models.Proxy, error) {
 func (c *ConfigurationRepository) ReadProxies() (*[]models.Proxy, error) {
 	proxies, err := gorm.G[models.Proxy](c.db).
 		Preload("App", nil).
+		Preload("Credentials", nil).
 		Preload("Service", nil).
+		Preload("Service.Targets", nil).
 		Find(c.ctx)
 	if err != nil {
 		return nil, err
@@ -58,7 +85,17 @@ func (c *ConfigurationRepository) ReadProxies() (*[]models.Proxy, error) {
 }
 
 func (c *ConfigurationRepository) UpdateProxy(id int, proxy *models.Proxy) error {
-	_, err := gorm.G[models.Proxy](c.db).Where("id = ?", id).Updates(c.ctx, *proxy)
+	err := validateProxy(proxy)
+	if err != nil {
+		return err
+	}
+
+	if proxy.APIKey != "" {
+		proxy.APIKeyHash = c.HashApiKey(proxy.APIKey)
+		proxy.APIKeyPrefix = getApiKeyPrefix(proxy.APIKey)
+	}
+
+	_, err = gorm.G[models.Proxy](c.db).Omit("App", "Service", "Credentials").Where("id = ?", id).Updates(c.ctx, *proxy)
 	if err != nil {
 		if strings.HasPrefix(err.Error(), errUniqueConstraintFailed.Error()) {
 			return errors.New("a proxy for the selected app and service already exists")
@@ -70,8 +107,72 @@ func (c *ConfigurationRepository) UpdateProxy(id int, proxy *models.Proxy) error
 	return nil
 }
 
+// RotateProxyKey issues a new API key for the proxy, the previous key stays
+// valid for the grace period.
+func (c *ConfigurationRepository) RotateProxyKey(id int, gracePeriod time.Duration) (string, error) {
+	apiKey := generateApiKey()
+
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		proxy, err := gorm.G[models.Proxy](tx).Where("id = ?", id).First(c.ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		_, err = gorm.G[models.ProxyCredential](tx).Where("proxy_id = ? AND expires_at <= ?", id, now).Delete(c.ctx)
+		if err != nil {
+			return err
+		}
+
+		if gracePeriod > 0 {
+			expiresAt := now.Add(gracePeriod)
+
+			// A key already expiring keeps its own deadline.
+			if proxy.APIKeyExpiresAt != nil && proxy.APIKeyExpiresAt.Before(expiresAt) {
+				expiresAt = *proxy.APIKeyExpiresAt
+			}
+
+			err = gorm.G[models.ProxyCredential](tx).Create(c.ctx, &models.ProxyCredential{
+				ProxyID:      proxy.ID,
+				APIKeyHash:   proxy.APIKeyHash,
+				APIKeyPrefix: proxy.APIKeyPrefix,
+				ExpiresAt:    &expiresAt,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = gorm.G[models.Proxy](tx).
+			Select("APIKeyHash", "APIKeyPrefix", "APIKeyExpiresAt").
+			Where("id = ?", id).
+			Updates(c.ctx, models.Proxy{
+				APIKeyHash:   c.HashApiKey(apiKey),
+				APIKeyPrefix: getApiKeyPrefix(apiKey),
+			})
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return apiKey, nil
+}
+
 func (c *ConfigurationRepository) DestroyProxy(id int) error {
-	_, err := gorm.G[models.Proxy](c.db).Where("id = ?", id).Delete(c.ctx)
+	_, err := gorm.G[models.ProxyCredential](c.db).Where("proxy_id = ?", id).Delete(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[models.Proxy](c.db).Where("id = ?", id).Delete(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[models.ProxyUsage](c.db).Where("proxy_id = ?", id).Delete(c.ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+	"gorm.io/gorm"
+)
+
+// encryptServiceKey seals the API key given by the caller, a masked key
+// leaves the stored one unchanged. A key looking like a ciphertext is sealed
+// as well, callers never see the stored ciphertexts.
+func encryptServiceKey(service *models.Service) error {
+	if service.APIKey == "" || service.APIKey == models.MaskedSecret {
+		service.APIKey = ""
+		return nil
+	}
+
+	apiKey, err := tools.EncryptSecret(service.APIKey)
+	if err != nil {
+		return err
+	}
+
+	service.APIKey = apiKey
+
+	return nil
+}
+
+// dropServiceKeyIndex removes the unique index of the service API keys, their
+// ciphertexts differ even for the same key.
+func dropServiceKeyIndex(db *gorm.DB) error {
+	index := "idx_services_api_key"
+	if !db.Migrator().HasIndex(&models.Service{}, index) {
+		return nil
+	}
+
+	return db.Migrator().DropIndex(&models.Service{}, index)
+}
+
+// reencryptServiceKeys seals the service API keys with the current master key,
+// only the plaintext ones unless all is set.
+func reencryptServiceKeys(db *gorm.DB, all bool) (int, error) {
+	count := 0
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var services []models.Service
+
+		err := tx.Select("id", "api_key").Find(&services).Error
+		if err != nil {
+			return err
+		}
+
+		for _, service := range services {
+			if service.APIKey == "" || (!all && tools.IsEncryptedSecret(service.APIKey)) {
+				continue
+			}
+
+			apiKey, err := tools.DecryptSecret(service.APIKey)
+			if err != nil {
+				return err
+			}
+
+			apiKey, err = tools.EncryptSecret(apiKey)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&models.Service{}).Where("id = ?", service.ID).UpdateColumn("api_key", apiKey).Error
+			if err != nil {
+				return err
+			}
+
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ReencryptServiceKeys seals every service API key with the current master
+// key, to be run once the master key is rotated.
+func (c *ConfigurationRepository) ReencryptServiceKeys() (int, error) {
+	return reencryptServiceKeys(c.db, true)
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/middlewarr/server/internal/models"
+	"github.com/middlewarr/server/internal/tools"
+)
+
+const (
+	testMasterKey         = "new master key"
+	testPreviousMasterKey = "previous master key"
+)
+
+// TestMain provides the master keys, they are loaded once per process.
+func TestMain(m *testing.M) {
+	os.Setenv("MIDDLEWARR_MASTER_KEY", testMasterKey)
+	os.Setenv("MIDDLEWARR_PREVIOUS_MASTER_KEY", testPreviousMasterKey)
+
+	os.Exit(m.Run())
+}
+
+func newTestAEAD(t *testing.T, material string) cipher.AEAD {
+	t.Helper()
+
+	key := sha256.Sum256([]byte(material))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return aead
+}
+
+// sealTestSecret encrypts a secret with the given master key only.
+func sealTestSecret(t *testing.T, material string, plaintext string) string {
+	t.Helper()
+
+	aead := newTestAEAD(t, material)
+
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+
+	return "enc:v1:" + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plaintext), nil))
+}
+
+// openTestSecret decrypts a secret with the given master key only.
+func openTestSecret(t *testing.T, material string, secret string) (string, bool) {
+	t.Helper()
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "enc:v1:"))
+	if err != nil {
+		return "", false
+	}
+
+	aead := newTestAEAD(t, material)
+	if len(sealed) < aead.NonceSize() {
+		return "", false
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+
+	return string(plaintext), err == nil
+}
+
+func TestEncryptServiceKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		apiKey string
+		stored bool
+	}{
+		{"plaintext", "service0123456789", true},
+		{"ciphertext lookalike", "enc:v1:" + base64.StdEncoding.EncodeToString([]byte("service0123456789abcdefghijklmnop")), true},
+		{"empty", "", false},
+		{"masked", models.MaskedSecret, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &models.Service{APIKey: tt.apiKey}
+			if err := encryptServiceKey(service); err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.stored {
+				if service.APIKey != "" {
+					t.Errorf("APIKey = %q, want the stored key kept", service.APIKey)
+				}
+				return
+			}
+
+			if service.APIKey == tt.apiKey {
+				t.Fatal("API key stored verbatim")
+			}
+
+			if apiKey, ok := openTestSecret(t, testMasterKey, service.APIKey); !ok || apiKey != tt.apiKey {
+				t.Errorf("stored key opens to %q, %v, want %q", apiKey, ok, tt.apiKey)
+			}
+		})
+	}
+}
+
+func TestReencryptServiceKeys(t *testing.T) {
+	db := openTestDB(t, &models.Service{})
+
+	current, err := tools.EncryptSecret("current0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services := []models.Service{
+		{Type: "sonarr", Name: "plaintext", URL: "http://sonarr-1:8989", APIKey: "plaintext0123456789"},
+		{Type: "sonarr", Name: "previous", URL: "http://sonarr-2:8989", APIKey: sealTestSecret(t, testPreviousMasterKey, "previous0123456789")},
+		{Type: "sonarr", Name: "current", URL: "http://sonarr-3:8989", APIKey: current},
+		{Type: "sonarr", Name: "empty", URL: "http://sonarr-4:8989"},
+	}
+
+	if err := db.Create(&services).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	readKeys := func() map[string]string {
+		var stored []models.Service
+		if err := db.Select("name", "api_key").Find(&stored).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		keys := make(map[string]string)
+		for _, service := range stored {
+			keys[service.Name] = service.APIKey
+		}
+
+		return keys
+	}
+
+	// At startup only the plaintext keys are sealed.
+	count, err := reencryptServiceKeys(db, false)
+	if err != nil || count != 1 {
+		t.Fatalf("reencryptServiceKeys(false) = %d, %v, want 1", count, err)
+	}
+
+	keys := readKeys()
+	if apiKey, ok := openTestSecret(t, testMasterKey, keys["plaintext"]); !ok || apiKey != "plaintext0123456789" {
+		t.Errorf("plaintext key opens to %q, %v", apiKey, ok)
+	}
+
+	if keys["previous"] != services[1].APIKey || keys["current"] != current || keys["empty"] != "" {
+		t.Errorf("sealed keys changed: %v", keys)
+	}
+
+	// After a rotation every key moves to the new master key.
+	count, err = reencryptServiceKeys(db, true)
+	if err != nil || count != 3 {
+		t.Fatalf("reencryptServiceKeys(true) = %d, %v, want 3", count, err)
+	}
+
+	want := map[string]string{
+		"plaintext": "plaintext0123456789",
+		"previous":  "previous0123456789",
+		"current":   "current0123456789",
+	}
+
+	keys = readKeys()
+	for name, plaintext := range want {
+		if apiKey, ok := openTestSecret(t, testMasterKey, keys[name]); !ok || apiKey != plaintext {
+			t.Errorf("%s key opens to %q, %v with the new master key, want %q", name, apiKey, ok, plaintext)
+		}
+
+		if _, ok := openTestSecret(t, testPreviousMasterKey, keys[name]); ok {
+			t.Errorf("%s key still opens with the previous master key", name)
+		}
+	}
+
+	if keys["empty"] != "" {
+		t.Errorf("empty key = %q", keys["empty"])
+	}
+}
+
+func TestReencryptServiceKeysUnknownKey(t *testing.T) {
+	db := openTestDB(t, &models.Service{})
+
+	sealed := sealTestSecret(t, "unknown master key", "service0123456789")
+	if err := db.Create(&models.Service{Type: "sonarr", Name: "unknown", APIKey: sealed}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reencryptServiceKeys(db, true); err == nil {
+		t.Fatal("reencryptServiceKeys() succeeded with an unknown master key")
+	}
+
+	// The transaction leaves the stored key untouched.
+	var service models.Service
+	if err := db.First(&service).Error; err != nil || service.APIKey != sealed {
+		t.Errorf("stored key = %q, %v, want it unchanged", service.APIKey, err)
+	}
+}
@@ -2,6 +2,8 @@ package store
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/middlewarr/server/internal/models"
@@ -9,12 +11,42 @@ import (
 	"gorm.io/gorm"
 )
 
+func validateServiceTargets(service *models.Service) error {
+	err := tools.ValidateServiceBalancing(service.Balancing)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range service.Targets {
+		u, err := url.Parse(target.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid target URL %s", target.URL)
+		}
+
+		if target.Weight <= 0 {
+			return errors.New("target weight must be greater than 0")
+		}
+	}
+
+	return nil
+}
+
 func (c *ConfigurationRepository) CreateService(service *models.Service) error {
 	err := tools.ValidateServiceType(service.Type)
 	if err != nil {
 		return err
 	}
 
+	err = validateServiceTargets(service)
+	if err != nil {
+		return err
+	}
+
+	err = encryptServiceKey(service)
+	if err != nil {
+		return err
+	}
+
 	err = gorm.G[models.Service](c.db).Create(c.ctx, service)
 	if err != nil {
 		if strings.HasPrefix(err.Error(), errUniqueConstraintFailed.Error()) {
@@ -29,6 +61,7 @@ func (c *ConfigurationRepository) CreateService(service *models.Service) error {
 
 func (c *ConfigurationRepository) ReadService(id int) (*models.Service, error) {
 	service, err := gorm.G[models.Service](c.db).
+		Preload("Targets", nil).
 		Preload("Proxies", nil).
 		Preload("Proxies.App", nil).
 		Preload("Proxies.Service", nil).
@@ -43,6 +76,7 @@ func (c *ConfigurationRepository) ReadService(id int) (*models.Service, error) {
 
 func (c *ConfigurationRepository) ReadServices() (*[]models.Service, error) {
 	services, err := gorm.G[models.Service](c.db).
+		Preload("Targets", nil).
 		Preload("Proxies", nil).
 		Preload("Proxies.App", nil).
 		Preload("Proxies.Service", nil).
@@ -57,7 +91,44 @@ func (c *ConfigurationRepository) ReadServices() (*[]models.Service, error) {
 func (c *ConfigurationRepository) UpdateService(id int, service *models.Service) error {
 	// TODO: Validate fields
 
-	_, err := gorm.G[models.Service](c.db).Where("id = ?", id).Updates(c.ctx, *service)
+	err := validateServiceTargets(service)
+	if err != nil {
+		return err
+	}
+
+	err = encryptServiceKey(service)
+	if err != nil {
+		return err
+	}
+
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		_, err := gorm.G[models.Service](tx).Omit("Targets", "Proxies").Where("id = ?", id).Updates(c.ctx, *service)
+		if err != nil {
+			return err
+		}
+
+		// Targets are replaced as a whole when provided.
+		if service.Targets == nil {
+			return nil
+		}
+
+		_, err = gorm.G[models.ServiceTarget](tx).Where("service_id = ?", id).Delete(c.ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, target := range service.Targets {
+			target.ID = 0
+			target.ServiceID = uint(id)
+
+			err = gorm.G[models.ServiceTarget](tx).Create(c.ctx, &target)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
 		if strings.HasPrefix(err.Error(), errUniqueConstraintFailed.Error()) {
 			return errors.New("a service with the same name already exists")
@@ -70,7 +141,15 @@ func (c *ConfigurationRepository) UpdateService(id int, service *models.Service)
 }
 
 func (c *ConfigurationRepository) DestroyService(id int) error {
-	_, err := gorm.G[models.Service](c.db).Where("id = ?", id).Delete(c.ctx)
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		_, err := gorm.G[models.ServiceTarget](tx).Where("service_id = ?", id).Delete(c.ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = gorm.G[models.Service](tx).Where("id = ?", id).Delete(c.ctx)
+		return err
+	})
 	if err != nil {
 		if strings.HasPrefix(err.Error(), errForeignKeyConstraintFailed.Error()) {
 			return errors.New("remove all proxies before deleting the service")
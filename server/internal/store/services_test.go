@@ -0,0 +1,43 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+func TestValidateServiceTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		weights []int
+		valid   bool
+	}{
+		{"default weight", `{"targets": [{"url": "http://sonarr-2:8989"}]}`, []int{1}, true},
+		{"weights", `{"balancing": "round_robin", "targets": [{"url": "http://sonarr-2:8989", "weight": 3}, {"url": "http://sonarr-3:8989", "weight": 1}]}`, []int{3, 1}, true},
+		{"zero weight", `{"targets": [{"url": "http://sonarr-2:8989", "weight": 0}]}`, []int{0}, false},
+		{"negative weight", `{"targets": [{"url": "http://sonarr-2:8989", "weight": -1}]}`, []int{-1}, false},
+		{"invalid URL", `{"targets": [{"url": "sonarr-2"}]}`, []int{1}, false},
+		{"invalid balancing", `{"balancing": "random", "targets": []}`, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var service models.Service
+			if err := json.Unmarshal([]byte(tt.service), &service); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, target := range service.Targets {
+				if target.Weight != tt.weights[i] {
+					t.Errorf("target %d weight %d, want %d", i, target.Weight, tt.weights[i])
+				}
+			}
+
+			if err := validateServiceTargets(&service); (err == nil) != tt.valid {
+				t.Errorf("error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
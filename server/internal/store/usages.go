@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/middlewarr/server/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (c *ConfigurationRepository) ReadProxyUsage(proxyID uint, period string, key string) (*models.ProxyUsage, error) {
+	usage, err := gorm.G[models.ProxyUsage](c.db).
+		Where("proxy_id = ? AND period = ? AND key = ?", proxyID, period, key).
+		First(c.ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &models.ProxyUsage{
+				ProxyID: proxyID,
+				Period:  period,
+				Key:     key,
+			}, nil
+		}
+
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// AddProxyUsage adds requests to the counter of a period.
+func (c *ConfigurationRepository) AddProxyUsage(proxyID uint, period string, key string, count int) error {
+	usage := &models.ProxyUsage{
+		ProxyID: proxyID,
+		Period:  period,
+		Key:     key,
+		Count:   count,
+	}
+
+	result := c.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "proxy_id"}, {Name: "period"}, {Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"count":      gorm.Expr("count + ?", count),
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(usage)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// DestroyProxyUsages removes the counters of past periods.
+func (c *ConfigurationRepository) DestroyProxyUsages(proxyID uint, period string, currentKey string) error {
+	_, err := gorm.G[models.ProxyUsage](c.db).
+		Where("proxy_id = ? AND period = ? AND key <> ?", proxyID, period, currentKey).
+		Delete(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
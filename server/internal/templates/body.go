@@ -0,0 +1,258 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TemplateBody constrains the JSON body of a route with JSON pointers, a `*`
+// segment matches any member or array item. Member names are matched
+// regardless of their case, as the *arr services bind them.
+type TemplateBody struct {
+	// Allowed lists the members which may be sent, with everything below them
+	// and the members of Fixed and Values.
+	Allowed   []string `json:"allowed,omitempty"`
+	Forbidden []string `json:"forbidden,omitempty"`
+	// Fixed and Values constrain the members when they are sent.
+	Fixed  map[string]any   `json:"fixed,omitempty"`
+	Values map[string][]any `json:"values,omitempty"`
+
+	allowed   [][]string
+	forbidden [][]string
+}
+
+type BodyViolation struct {
+	Rule    string `json:"rule"`
+	Pointer string `json:"pointer"`
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("invalid JSON pointer " + pointer)
+	}
+
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+	}
+
+	return segments, nil
+}
+
+func formatPointer(segments []string) string {
+	var pointer strings.Builder
+
+	for _, segment := range segments {
+		pointer.WriteString("/")
+		pointer.WriteString(strings.ReplaceAll(strings.ReplaceAll(segment, "~", "~0"), "/", "~1"))
+	}
+
+	return pointer.String()
+}
+
+func (t *TemplateBody) UnmarshalJSON(data []byte) error {
+	type templateBody TemplateBody
+
+	var body templateBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+
+	*t = TemplateBody(body)
+
+	var err error
+
+	if t.allowed, err = parsePointers(t.Allowed); err != nil {
+		return err
+	}
+
+	if t.forbidden, err = parsePointers(t.Forbidden); err != nil {
+		return err
+	}
+
+	for _, pointer := range slices.Concat(slices.Collect(maps.Keys(t.Fixed)), slices.Collect(maps.Keys(t.Values))) {
+		segments, err := parsePointer(pointer)
+		if err != nil {
+			return err
+		}
+
+		if len(t.allowed) > 0 {
+			t.allowed = append(t.allowed, segments)
+		}
+	}
+
+	return nil
+}
+
+func matchSegments(pattern []string, path []string) bool {
+	for i := range pattern {
+		if pattern[i] != "*" && !strings.EqualFold(pattern[i], path[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolve calls fn with every value of the document matching the pointer.
+func resolve(value any, pattern []string, path []string, fn func(path []string, value any)) {
+	if len(pattern) == 0 {
+		fn(path, value)
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if pattern[0] == "*" || strings.EqualFold(pattern[0], key) {
+				resolve(child, pattern[1:], append(slices.Clone(path), key), fn)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			index := strconv.Itoa(i)
+			if pattern[0] == "*" || pattern[0] == index {
+				resolve(child, pattern[1:], append(slices.Clone(path), index), fn)
+			}
+		}
+	}
+}
+
+// checkAllowed walks the document down to the allowed members, anything else
+// on the way is a violation.
+func (t TemplateBody) checkAllowed(value any, path []string) *BodyViolation {
+	visit := func(child any, childPath []string) *BodyViolation {
+		ancestor := false
+
+		for _, allowed := range t.allowed {
+			if len(allowed) <= len(childPath) && matchSegments(allowed, childPath[:len(allowed)]) {
+				return nil
+			}
+
+			if len(allowed) > len(childPath) && matchSegments(allowed[:len(childPath)], childPath) {
+				ancestor = true
+			}
+		}
+
+		if !ancestor {
+			return &BodyViolation{Rule: "not allowed", Pointer: formatPointer(childPath)}
+		}
+
+		return t.checkAllowed(child, childPath)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			if violation := visit(v[key], append(slices.Clone(path), key)); violation != nil {
+				return violation
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if violation := visit(child, append(slices.Clone(path), strconv.Itoa(i))); violation != nil {
+				return violation
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate checks a decoded JSON document against the rules and returns the
+// first violated one.
+func (t TemplateBody) Validate(document any) *BodyViolation {
+	var violation *BodyViolation
+
+	for _, forbidden := range t.forbidden {
+		resolve(document, forbidden, nil, func(path []string, _ any) {
+			if violation == nil {
+				violation = &BodyViolation{Rule: "forbidden", Pointer: formatPointer(path)}
+			}
+		})
+
+		if violation != nil {
+			return violation
+		}
+	}
+
+	if len(t.allowed) > 0 {
+		if violation := t.checkAllowed(document, nil); violation != nil {
+			return violation
+		}
+	}
+
+	for _, pointer := range slices.Sorted(maps.Keys(t.Fixed)) {
+		segments, _ := parsePointer(pointer)
+		expected := normalizeJSON(t.Fixed[pointer])
+
+		resolve(document, segments, nil, func(path []string, value any) {
+			if violation == nil && !reflect.DeepEqual(value, expected) {
+				violation = &BodyViolation{Rule: "must be " + formatJSON(expected), Pointer: formatPointer(path)}
+			}
+		})
+
+		if violation != nil {
+			return violation
+		}
+	}
+
+	for _, pointer := range slices.Sorted(maps.Keys(t.Values)) {
+		segments, _ := parsePointer(pointer)
+
+		resolve(document, segments, nil, func(path []string, value any) {
+			if violation != nil {
+				return
+			}
+
+			for _, allowed := range t.Values[pointer] {
+				if reflect.DeepEqual(value, normalizeJSON(allowed)) {
+					return
+				}
+			}
+
+			values := make([]string, len(t.Values[pointer]))
+			for i, allowed := range t.Values[pointer] {
+				values[i] = formatJSON(allowed)
+			}
+
+			violation = &BodyViolation{Rule: "must be one of " + strings.Join(values, ", "), Pointer: formatPointer(path)}
+		})
+
+		if violation != nil {
+			return violation
+		}
+	}
+
+	return nil
+}
+
+// normalizeJSON brings a template value to the types of a decoded document.
+func normalizeJSON(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+
+	return normalized
+}
+
+func formatJSON(value any) string {
+	data, _ := json.Marshal(value)
+
+	return string(data)
+}
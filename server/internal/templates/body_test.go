@@ -0,0 +1,74 @@
+package templates
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTemplateBodyValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		document  string
+		violation *BodyViolation
+	}{
+		{"no rules", `{}`, `{"a": 1}`, nil},
+		{"allowed", `{"allowed": ["/title"]}`, `{"title": "x"}`, nil},
+		{"allowed any case", `{"allowed": ["/title"]}`, `{"Title": "x"}`, nil},
+		{"allowed below", `{"allowed": ["/monitor"]}`, `{"monitor": {"seasons": [1, 2]}}`, nil},
+		{"not allowed", `{"allowed": ["/title"]}`, `{"title": "x", "path": "/tv"}`, &BodyViolation{"not allowed", "/path"}},
+		{"allowed nested", `{"allowed": ["/options/monitor"]}`, `{"options": {"monitor": "all", "search": true}}`, &BodyViolation{"not allowed", "/options/search"}},
+		{"allowed wildcard", `{"allowed": ["/seasons/*/monitored"]}`, `{"seasons": [{"monitored": true}, {"monitored": false}]}`, nil},
+		{"allowed wildcard member", `{"allowed": ["/seasons/*/monitored"]}`, `{"seasons": [{"monitored": true}, {"statistics": {}}]}`, &BodyViolation{"not allowed", "/seasons/1/statistics"}},
+		{"allowed array", `{"allowed": ["/ids"]}`, `[1, 2]`, &BodyViolation{"not allowed", "/0"}},
+		{"allowed with fixed", `{"allowed": ["/title"], "fixed": {"/monitored": true}}`, `{"title": "x", "monitored": true}`, nil},
+		{"escaped pointer", `{"allowed": ["/a~1b/c~0d"]}`, `{"a/b": {"c~d": 1}}`, nil},
+		{"forbidden", `{"forbidden": ["/path"]}`, `{"title": "x", "PATH": "/tv"}`, &BodyViolation{"forbidden", "/PATH"}},
+		{"forbidden absent", `{"forbidden": ["/path"]}`, `{"title": "x"}`, nil},
+		{"forbidden wildcard", `{"forbidden": ["/*/path"]}`, `[{"title": "x"}, {"path": "/tv"}]`, &BodyViolation{"forbidden", "/1/path"}},
+		{"forbidden over allowed", `{"allowed": ["/options"], "forbidden": ["/options/deleteFiles"]}`, `{"options": {"deleteFiles": true}}`, &BodyViolation{"forbidden", "/options/deleteFiles"}},
+		{"fixed", `{"fixed": {"/monitored": true}}`, `{"monitored": true}`, nil},
+		{"fixed differs", `{"fixed": {"/monitored": true}}`, `{"monitored": false}`, &BodyViolation{"must be true", "/monitored"}},
+		{"fixed absent", `{"fixed": {"/monitored": true}}`, `{}`, nil},
+		{"fixed object", `{"fixed": {"/options": {"search": false}}}`, `{"options": {"search": false}}`, nil},
+		{"fixed number", `{"fixed": {"/qualityProfileId": 1}}`, `{"qualityProfileId": 1.0}`, nil},
+		{"values", `{"values": {"/seriesType": ["standard", "anime"]}}`, `{"seriesType": "anime"}`, nil},
+		{"values differ", `{"values": {"/seriesType": ["standard", "anime"]}}`, `{"seriesType": "daily"}`, &BodyViolation{`must be one of "standard", "anime"`, "/seriesType"}},
+		{"values wildcard", `{"values": {"/tags/*": [1, 2]}}`, `{"tags": [1, 3]}`, &BodyViolation{"must be one of 1, 2", "/tags/1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body TemplateBody
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			var document any
+			if err := json.Unmarshal([]byte(tt.document), &document); err != nil {
+				t.Fatal(err)
+			}
+
+			violation := body.Validate(document)
+
+			switch {
+			case violation == nil && tt.violation == nil:
+			case violation == nil || tt.violation == nil || *violation != *tt.violation:
+				t.Errorf("Validate() = %v, want %v", violation, tt.violation)
+			}
+		})
+	}
+}
+
+func TestTemplateBodyInvalidPointer(t *testing.T) {
+	for _, body := range []string{
+		`{"allowed": ["title"]}`,
+		`{"forbidden": ["title"]}`,
+		`{"fixed": {"title": "x"}}`,
+	} {
+		var b TemplateBody
+		if err := json.Unmarshal([]byte(body), &b); err == nil {
+			t.Errorf("%s accepted", body)
+		}
+	}
+}
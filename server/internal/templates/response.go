@@ -0,0 +1,191 @@
+package templates
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// MaskedValue replaces the masked members of a response.
+const MaskedValue = "********"
+
+// TemplateResponse rewrites the JSON responses of a route with JSON pointers
+// as in TemplateBody. Include keeps only the given members, with everything
+// below them, then the Remove members are dropped and the Mask ones replaced.
+type TemplateResponse struct {
+	Include []string `json:"include,omitempty"`
+	Remove  []string `json:"remove,omitempty"`
+	Mask    []string `json:"mask,omitempty"`
+
+	include  [][]string
+	remove   [][]string
+	mask     [][]string
+	identity string
+}
+
+func parsePointers(pointers []string) ([][]string, error) {
+	var parsed [][]string
+
+	for _, pointer := range pointers {
+		segments, err := parsePointer(pointer)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, segments)
+	}
+
+	return parsed, nil
+}
+
+func (t *TemplateResponse) UnmarshalJSON(data []byte) error {
+	type templateResponse TemplateResponse
+
+	var response templateResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return err
+	}
+
+	*t = TemplateResponse(response)
+
+	var err error
+
+	if t.include, err = parsePointers(t.Include); err != nil {
+		return err
+	}
+
+	if t.remove, err = parsePointers(t.Remove); err != nil {
+		return err
+	}
+
+	if t.mask, err = parsePointers(t.Mask); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(b)
+	t.identity = hex.EncodeToString(sum[:8])
+
+	return nil
+}
+
+// Identity tells transforms apart, the responses they produce are cached
+// separately.
+func (t *TemplateResponse) Identity() string {
+	return t.identity
+}
+
+// project keeps the members matching the patterns at the given depth.
+func project(value any, patterns [][]string, depth int) any {
+	visit := func(key string) (bool, [][]string) {
+		var pending [][]string
+
+		for _, pattern := range patterns {
+			if pattern[depth] != "*" && !strings.EqualFold(pattern[depth], key) {
+				continue
+			}
+
+			if len(pattern) == depth+1 {
+				return true, nil
+			}
+
+			pending = append(pending, pattern)
+		}
+
+		return false, pending
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		projected := make(map[string]any)
+
+		for key, child := range v {
+			if whole, pending := visit(key); whole {
+				projected[key] = child
+			} else if len(pending) > 0 {
+				projected[key] = project(child, pending, depth+1)
+			}
+		}
+
+		return projected
+	case []any:
+		projected := make([]any, 0, len(v))
+
+		for i, child := range v {
+			if whole, pending := visit(strconv.Itoa(i)); whole {
+				projected = append(projected, child)
+			} else if len(pending) > 0 {
+				projected = append(projected, project(child, pending, depth+1))
+			}
+		}
+
+		return projected
+	}
+
+	return value
+}
+
+// members calls fn with the objects holding a member matching the pattern,
+// array items are not removed nor masked.
+func members(value any, pattern []string, fn func(object map[string]any, key string)) {
+	if len(pattern) == 0 {
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if pattern[0] != "*" && !strings.EqualFold(pattern[0], key) {
+				continue
+			}
+
+			if len(pattern) == 1 {
+				fn(v, key)
+			} else {
+				members(child, pattern[1:], fn)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if pattern[0] == "*" || pattern[0] == strconv.Itoa(i) {
+				members(child, pattern[1:], fn)
+			}
+		}
+	}
+}
+
+// Transform applies the rules to a decoded JSON document.
+func (t *TemplateResponse) Transform(document any) any {
+	if len(t.include) > 0 {
+		var whole bool
+		for _, pattern := range t.include {
+			whole = whole || len(pattern) == 0
+		}
+
+		if !whole {
+			document = project(document, t.include, 0)
+		}
+	}
+
+	for _, pattern := range t.remove {
+		members(document, pattern, func(object map[string]any, key string) {
+			delete(object, key)
+		})
+	}
+
+	for _, pattern := range t.mask {
+		members(document, pattern, func(object map[string]any, key string) {
+			if object[key] != nil {
+				object[key] = MaskedValue
+			}
+		})
+	}
+
+	return document
+}
@@ -0,0 +1,183 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TemplateRoute lists the methods allowed on a path. In template files it is
+// either a plain array of methods or an object carrying route options.
+type TemplateRoute struct {
+	Methods    []string       `json:"methods"`
+	Cache      *TemplateCache `json:"cache,omitempty"`
+	Timeout    Duration       `json:"timeout,omitempty"`
+	Idempotent *bool          `json:"idempotent,omitempty"`
+	Stream     bool           `json:"stream,omitempty"`
+	// Query lists the allowed query parameters, any parameter is allowed when
+	// it is not set.
+	Query map[string]TemplateQueryParam `json:"query,omitempty"`
+	Body  *TemplateBody                 `json:"body,omitempty"`
+	// Response rewrites the JSON responses before they reach the client.
+	Response *TemplateResponse `json:"response,omitempty"`
+}
+
+type TemplateCache struct {
+	TTL          Duration `json:"ttl"`
+	StaleIfError bool     `json:"staleIfError,omitempty"`
+}
+
+// TemplateQueryParam constrains the values of a query parameter. Numbers out
+// of the min/max range are rejected, or brought back in it with clamp.
+type TemplateQueryParam struct {
+	Enum    []string `json:"enum,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Clamp   bool     `json:"clamp,omitempty"`
+
+	patternRegex *regexp.Regexp
+}
+
+func (t *TemplateQueryParam) UnmarshalJSON(data []byte) error {
+	type templateQueryParam TemplateQueryParam
+
+	var param templateQueryParam
+	if err := json.Unmarshal(data, &param); err != nil {
+		return err
+	}
+
+	*t = TemplateQueryParam(param)
+
+	if t.Pattern != "" {
+		regex, err := regexp.Compile("^(?:" + t.Pattern + ")$")
+		if err != nil {
+			return err
+		}
+
+		t.patternRegex = regex
+	}
+
+	return nil
+}
+
+// Validate checks a value against the constraints and returns the value to
+// forward, which differs from the given one when it was clamped.
+func (t TemplateQueryParam) Validate(value string) (string, error) {
+	if len(t.Enum) > 0 && !slices.Contains(t.Enum, value) {
+		return "", fmt.Errorf("must be one of %s", strings.Join(t.Enum, ", "))
+	}
+
+	if t.patternRegex != nil && !t.patternRegex.MatchString(value) {
+		return "", fmt.Errorf("must match %s", t.Pattern)
+	}
+
+	if t.Min == nil && t.Max == nil {
+		return value, nil
+	}
+
+	// NaN would pass any range.
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return "", errors.New("must be a number")
+	}
+
+	switch {
+	case t.Min != nil && number < *t.Min:
+		if !t.Clamp {
+			return "", fmt.Errorf("must be at least %s", formatNumber(*t.Min))
+		}
+
+		return formatNumber(*t.Min), nil
+	case t.Max != nil && number > *t.Max:
+		if !t.Clamp {
+			return "", fmt.Errorf("must be at most %s", formatNumber(*t.Max))
+		}
+
+		return formatNumber(*t.Max), nil
+	}
+
+	return value, nil
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func (t *TemplateRoute) UnmarshalJSON(data []byte) error {
+	var methods []string
+	if err := json.Unmarshal(data, &methods); err == nil {
+		*t = TemplateRoute{Methods: methods}
+		return nil
+	}
+
+	type templateRoute TemplateRoute
+
+	var route templateRoute
+	if err := json.Unmarshal(data, &route); err != nil {
+		return err
+	}
+
+	*t = TemplateRoute(route)
+
+	return nil
+}
+
+func (t TemplateRoute) MarshalJSON() ([]byte, error) {
+	// Keep the short form when the route has no options.
+	if reflect.DeepEqual(t, TemplateRoute{Methods: t.Methods}) {
+		return json.Marshal(t.Methods)
+	}
+
+	type templateRoute TemplateRoute
+
+	return json.Marshal(templateRoute(t))
+}
+
+// Duration accepts either a Go duration string ("5m", "1h30m"), a number of
+// days ("30d") or a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(time.Duration(v * float64(time.Second)))
+	case string:
+		if days, ok := strings.CutSuffix(v, "d"); ok {
+			n, err := strconv.ParseFloat(days, 64)
+			if err != nil {
+				return fmt.Errorf("invalid duration %q", v)
+			}
+
+			*d = Duration(time.Duration(n * float64(24*time.Hour)))
+			return nil
+		}
+
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+
+		*d = Duration(duration)
+	default:
+		return errors.New("invalid duration")
+	}
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
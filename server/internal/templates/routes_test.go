@@ -0,0 +1,59 @@
+package templates
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTemplateQueryParamValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		param     string
+		value     string
+		validated string
+		valid     bool
+	}{
+		{"no constraint", `{}`, "anything", "anything", true},
+		{"enum", `{"enum": ["asc", "desc"]}`, "desc", "desc", true},
+		{"not in enum", `{"enum": ["asc", "desc"]}`, "DESC", "", false},
+		{"pattern", `{"pattern": "[0-9]+"}`, "42", "42", true},
+		{"pattern anchored", `{"pattern": "[0-9]+"}`, "42abc", "", false},
+		{"pattern alternation anchored", `{"pattern": "a|b"}`, "ab", "", false},
+		{"within range", `{"min": 1, "max": 100}`, "50", "50", true},
+		{"range bounds", `{"min": 1, "max": 100}`, "100", "100", true},
+		{"below min", `{"min": 1}`, "0", "", false},
+		{"above max", `{"max": 100}`, "250", "", false},
+		{"clamped to min", `{"min": 1, "clamp": true}`, "-5", "1", true},
+		{"clamped to max", `{"max": 100, "clamp": true}`, "250", "100", true},
+		{"clamped fraction", `{"max": 0.5, "clamp": true}`, "2", "0.5", true},
+		{"not a number", `{"max": 100, "clamp": true}`, "many", "", false},
+		{"not a number NaN", `{"min": 1, "max": 100}`, "NaN", "", false},
+		{"not a number Inf", `{"max": 100, "clamp": true}`, "-Inf", "", false},
+		{"enum and range", `{"enum": ["10", "500"], "max": 100}`, "500", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var param TemplateQueryParam
+			if err := json.Unmarshal([]byte(tt.param), &param); err != nil {
+				t.Fatal(err)
+			}
+
+			validated, err := param.Validate(tt.value)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Validate(%q) = %v, want valid %v", tt.value, err, tt.valid)
+			}
+
+			if validated != tt.validated {
+				t.Errorf("Validate(%q) = %q, want %q", tt.value, validated, tt.validated)
+			}
+		})
+	}
+}
+
+func TestTemplateQueryParamInvalidPattern(t *testing.T) {
+	var param TemplateQueryParam
+	if err := json.Unmarshal([]byte(`{"pattern": "[0-9"}`), &param); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
@@ -11,13 +11,47 @@ import (
 	"github.com/middlewarr/server/internal/tools"
 )
 
-type TemplateEndpoints map[string]map[string][]string
+type TemplateEndpoints map[string]map[string]TemplateRoute
 
 type Template struct {
-	ID        string            `json:"id"`
-	Name      string            `json:"name"`
-	URL       string            `json:"url"`
-	Endpoints TemplateEndpoints `json:"endpoints"`
+	ID        string                     `json:"id"`
+	Name      string                     `json:"name"`
+	URL       string                     `json:"url"`
+	Endpoints TemplateEndpoints          `json:"endpoints"`
+	SignalR   map[string]TemplateSignalR `json:"signalr,omitempty"`
+	// Commands lists the command names allowed per service type, any command
+	// is allowed for a type without an entry.
+	Commands map[string][]string `json:"commands,omitempty"`
+	// Unmask lists per service type the sensitive response fields left
+	// visible, "*" for all of them. The service API key is always masked.
+	Unmask map[string][]string `json:"unmask,omitempty"`
+	// RewriteUrls replaces the service address with the publicUrl setting in
+	// the responses.
+	RewriteUrls bool `json:"rewriteUrls,omitempty"`
+	// Strict validates the parameters and JSON bodies of the requests against
+	// the OpenAPI specs of the service.
+	Strict bool `json:"strict,omitempty"`
+
+	routes map[string]*RouteTrie
+}
+
+// Routes returns the endpoints of a service type compiled when the templates
+// are loaded, nil when the type has none.
+func (t *Template) Routes(serviceType string) *RouteTrie {
+	return t.routes[serviceType]
+}
+
+// IsAPIPath tells whether a path belongs to the API of the services, the one
+// described by their OpenAPI specs.
+func IsAPIPath(path string) bool {
+	return strings.HasPrefix(strings.ToLower(path), "/api/")
+}
+
+// TemplateSignalR grants access to the SignalR hub of a service type. When
+// Messages is set, only those message names are forwarded to the client.
+type TemplateSignalR struct {
+	Allow    bool     `json:"allow"`
+	Messages []string `json:"messages,omitempty"`
 }
 
 type TemplateFiles struct {
@@ -99,6 +133,8 @@ func initTemplateFiles() (*TemplateFiles, error) {
 			continue
 		}
 
+		template.routes = compileTemplateRoutes(template)
+
 		templates = append(templates, template)
 	}
 
@@ -109,6 +145,31 @@ func initTemplateFiles() (*TemplateFiles, error) {
 
 }
 
+// compileTemplateRoutes builds the tries of a template, its invalid endpoints
+// are left out.
+func compileTemplateRoutes(template Template) map[string]*RouteTrie {
+	l := tools.GetLogger()
+
+	routes := make(map[string]*RouteTrie)
+
+	for serviceType, endpoints := range template.Endpoints {
+		trie, errs := compileRoutes(endpoints)
+
+		for _, err := range errs {
+			l.Warn().
+				Err(err).
+				Str("template_id", template.ID).
+				Str("template_name", template.Name).
+				Str("template_type", serviceType).
+				Msg("Invalid template endpoint, left out")
+		}
+
+		routes[serviceType] = trie
+	}
+
+	return routes
+}
+
 func ReadTemplates() (*[]Template, error) {
 	t := getTemplateFiles()
 
@@ -171,8 +232,13 @@ func validateTemplateFile(file string, template Template) error {
 			return errors.New("invalid service type")
 		}
 
-		for path, methods := range endpoints {
-			specsMethods, ok := specs.Paths[path]
+		for path, route := range endpoints {
+			// The MediaCover, feed and indexer paths are not in the specs.
+			if !IsAPIPath(path) {
+				continue
+			}
+
+			specsMethods, ok := specs.Paths[openAPIPath(path)]
 			if !ok {
 				l.Warn().
 					Str("path", path).
@@ -180,9 +246,14 @@ func validateTemplateFile(file string, template Template) error {
 					Str("template_name", template.Name).
 					Str("service_type", serviceType).
 					Msg("Invalid path in route")
+
+				// Strict templates are validated against every operation.
+				if template.Strict {
+					return errors.New("invalid path in strict route")
+				}
 			} else {
 				// Check methods only if path is valid.
-				for _, configMethod := range methods {
+				for _, configMethod := range route.Methods {
 					if _, ok := specsMethods[strings.ToLower(configMethod)]; !ok {
 						l.Warn().
 							Str("method", configMethod).
@@ -191,6 +262,10 @@ func validateTemplateFile(file string, template Template) error {
 							Str("template_name", template.Name).
 							Str("service_type", serviceType).
 							Msg("Invalid method path in route")
+
+						if template.Strict {
+							return errors.New("invalid method path in strict route")
+						}
 					}
 				}
 			}
@@ -0,0 +1,597 @@
+package templates
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Placeholder types, "{id:int}" only matches an integer and "{id:guid}" a
+// GUID. A placeholder without a type matches any segment, "{*}" matches the
+// rest of the path.
+const (
+	placeholderString   string = "string"
+	placeholderInt      string = "int"
+	placeholderGUID     string = "guid"
+	placeholderCatchAll string = "*"
+)
+
+// RouteEndpoint is a method allowed on a template path.
+type RouteEndpoint struct {
+	Method string
+	Path   string
+	Route  TemplateRoute
+
+	params []*routeParam
+}
+
+// OpenAPIPath returns the path as written in the OpenAPI specs, without the
+// placeholder types.
+func (e *RouteEndpoint) OpenAPIPath() string {
+	return openAPIPath(e.Path)
+}
+
+// RouteMatch is the endpoint matching a request with the values of the path
+// placeholders.
+type RouteMatch struct {
+	Endpoint *RouteEndpoint
+	Params   map[string]string
+}
+
+type routeParam struct {
+	name   string
+	kind   string
+	prefix string
+	suffix string
+	// index is the segment of the placeholder in the path of an endpoint.
+	index int
+	node  *routeNode
+}
+
+// matches tells whether a segment fits the placeholder and returns its value.
+func (p *routeParam) matches(segment string) (string, bool) {
+	if len(segment) <= len(p.prefix)+len(p.suffix) {
+		return "", false
+	}
+
+	if !strings.EqualFold(segment[:len(p.prefix)], p.prefix) || !strings.EqualFold(segment[len(segment)-len(p.suffix):], p.suffix) {
+		return "", false
+	}
+
+	value := segment[len(p.prefix) : len(segment)-len(p.suffix)]
+
+	switch p.kind {
+	case placeholderInt:
+		digits := strings.TrimPrefix(value, "-")
+		if digits == "" || strings.ContainsFunc(digits, func(c rune) bool { return c < '0' || c > '9' }) {
+			return "", false
+		}
+	case placeholderGUID:
+		if !isGUID(value) {
+			return "", false
+		}
+	}
+
+	return value, true
+}
+
+func (p *routeParam) String() string {
+	return p.prefix + "{" + p.name + ":" + p.kind + "}" + p.suffix
+}
+
+// equals tells whether two placeholders match the same segments, whatever
+// their name.
+func (p *routeParam) equals(o *routeParam) bool {
+	return p.kind == o.kind && strings.EqualFold(p.prefix, o.prefix) && strings.EqualFold(p.suffix, o.suffix)
+}
+
+// affixExcess returns the part of the longest affix past the shortest one,
+// which the value of the placeholder with the shortest affix has to start or
+// end with. ok is false when the affixes differ.
+func affixExcess(a string, b string, suffix bool) (excess string, ok bool) {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+
+	if suffix {
+		return a[:len(a)-len(b)], strings.HasSuffix(a, b)
+	}
+
+	return a[len(b):], strings.HasPrefix(a, b)
+}
+
+// accepts tells whether a value of the placeholder may start or end with
+// the text.
+func (p *routeParam) accepts(text string, suffix bool) bool {
+	switch p.kind {
+	case placeholderInt:
+		if !suffix {
+			text = strings.TrimPrefix(text, "-")
+		}
+
+		return !strings.ContainsFunc(text, func(c rune) bool { return c < '0' || c > '9' })
+	case placeholderGUID:
+		return !strings.ContainsFunc(text, func(c rune) bool { return c != '-' && !strings.ContainsRune("0123456789abcdef", c) })
+	}
+
+	return true
+}
+
+// within tells whether every segment matched by the placeholder is matched
+// by the other one.
+func (p *routeParam) within(o *routeParam) bool {
+	if len(p.prefix) < len(o.prefix) || len(p.suffix) < len(o.suffix) {
+		return false
+	}
+
+	prefix, ok := affixExcess(p.prefix, o.prefix, false)
+	if !ok {
+		return false
+	}
+
+	suffix, ok := affixExcess(p.suffix, o.suffix, true)
+	if !ok {
+		return false
+	}
+
+	return o.kind == placeholderString || (o.kind == p.kind && prefix == "" && suffix == "")
+}
+
+// overlaps tells whether a segment may be matched by both placeholders. It
+// errs on the side of overlapping when that cannot be told apart easily.
+func (p *routeParam) overlaps(o *routeParam) bool {
+	prefix, ok := affixExcess(p.prefix, o.prefix, false)
+	if !ok {
+		return false
+	}
+
+	suffix, ok := affixExcess(p.suffix, o.suffix, true)
+	if !ok {
+		return false
+	}
+
+	// The excess belongs to the value of the placeholder with the shortest
+	// affix.
+	if len(p.prefix) < len(o.prefix) && !p.accepts(prefix, false) || len(o.prefix) < len(p.prefix) && !o.accepts(prefix, false) {
+		return false
+	}
+
+	if len(p.suffix) < len(o.suffix) && !p.accepts(suffix, true) || len(o.suffix) < len(p.suffix) && !o.accepts(suffix, true) {
+		return false
+	}
+
+	typed := p.kind != placeholderString && o.kind != placeholderString
+
+	return !typed || p.kind == o.kind || prefix != "" || suffix != ""
+}
+
+// compareParams orders the placeholders of a segment, the most constrained is
+// tried first: typed ones, then the ones with the longest literal parts.
+func compareParams(a, b *routeParam) int {
+	return cmp.Or(
+		cmp.Compare(boolToInt(b.kind != placeholderString), boolToInt(a.kind != placeholderString)),
+		cmp.Compare(len(b.prefix)+len(b.suffix), len(a.prefix)+len(a.suffix)),
+	)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+type routeNode struct {
+	literals  map[string]*routeNode
+	params    []*routeParam
+	catchAll  map[string]*RouteEndpoint
+	endpoints map[string]*RouteEndpoint
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{literals: make(map[string]*routeNode)}
+}
+
+func (n *routeNode) clone() *routeNode {
+	c := &routeNode{
+		literals:  make(map[string]*routeNode, len(n.literals)),
+		catchAll:  maps.Clone(n.catchAll),
+		endpoints: maps.Clone(n.endpoints),
+	}
+
+	for key, child := range n.literals {
+		c.literals[key] = child.clone()
+	}
+
+	for _, param := range n.params {
+		branch := *param
+		branch.node = param.node.clone()
+		c.params = append(c.params, &branch)
+	}
+
+	return c
+}
+
+// mergeEndpoints adds the methods missing from the endpoints.
+func mergeEndpoints(endpoints map[string]*RouteEndpoint, other map[string]*RouteEndpoint) map[string]*RouteEndpoint {
+	for method, endpoint := range other {
+		if endpoints == nil {
+			endpoints = make(map[string]*RouteEndpoint)
+		}
+
+		if _, ok := endpoints[method]; !ok {
+			endpoints[method] = endpoint
+		}
+	}
+
+	return endpoints
+}
+
+// merge adds the routes of another node, the ones of the node take
+// precedence.
+func (n *routeNode) merge(other *routeNode) {
+	n.endpoints = mergeEndpoints(n.endpoints, other.endpoints)
+	n.catchAll = mergeEndpoints(n.catchAll, other.catchAll)
+
+	for key, child := range other.literals {
+		if own, ok := n.literals[key]; ok {
+			own.merge(child)
+		} else {
+			n.literals[key] = child.clone()
+		}
+	}
+
+	for _, param := range other.params {
+		if index := slices.IndexFunc(n.params, param.equals); index >= 0 {
+			n.params[index].node.merge(param.node)
+			continue
+		}
+
+		branch := *param
+		branch.node = param.node.clone()
+		n.params = append(n.params, &branch)
+	}
+
+	slices.SortStableFunc(n.params, compareParams)
+}
+
+// resolve merges the branches a segment may take into the one it takes, the
+// catch-all endpoints of a node apply to its whole subtree. Placeholders
+// matching some segments in common without one including the other cannot
+// be ordered and are rejected.
+func (n *routeNode) resolve(catchAll map[string]*RouteEndpoint) error {
+	n.endpoints = mergeEndpoints(n.endpoints, catchAll)
+	n.catchAll = mergeEndpoints(n.catchAll, catchAll)
+
+	// From the last one, for each placeholder to hold the ones after it.
+	for i := len(n.params) - 1; i >= 0; i-- {
+		for _, other := range n.params[i+1:] {
+			switch {
+			case n.params[i].within(other):
+				n.params[i].node.merge(other.node)
+			case n.params[i].overlaps(other):
+				return fmt.Errorf("ambiguous placeholders %s and %s", n.params[i], other)
+			}
+		}
+	}
+
+	for key, child := range n.literals {
+		for _, param := range n.params {
+			if _, ok := param.matches(key); ok {
+				child.merge(param.node)
+			}
+		}
+	}
+
+	for _, child := range n.literals {
+		if err := child.resolve(n.catchAll); err != nil {
+			return err
+		}
+	}
+
+	for _, param := range n.params {
+		if err := param.node.resolve(n.catchAll); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// next returns the node a segment leads to, nil when there is none.
+func (n *routeNode) next(segment string) *routeNode {
+	if child, ok := n.literals[strings.ToLower(segment)]; ok {
+		return child
+	}
+
+	for _, param := range n.params {
+		if _, ok := param.matches(segment); ok {
+			return param.node
+		}
+	}
+
+	return nil
+}
+
+// RouteTrie matches the requests against the endpoints of a service type,
+// segment by segment and without going back: a literal segment, matched
+// regardless of its case, takes precedence over the placeholders, which take
+// precedence over the catch-all. The branches a segment could also have
+// taken are merged into the one it takes when the routes are compiled.
+type RouteTrie struct {
+	root      *routeNode
+	endpoints []*RouteEndpoint
+}
+
+func isGUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+
+	for i, c := range value {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// parsePlaceholder splits a segment around its placeholder, ok is false for a
+// literal segment.
+func parsePlaceholder(segment string) (param *routeParam, ok bool, err error) {
+	start := strings.Index(segment, "{")
+	end := strings.LastIndex(segment, "}")
+
+	if start < 0 && end < 0 {
+		return nil, false, nil
+	}
+
+	if start < 0 || end < start || strings.Count(segment, "{") > 1 || strings.Count(segment, "}") > 1 {
+		return nil, false, fmt.Errorf("invalid placeholder in segment %s", segment)
+	}
+
+	if segment[start+1:end] == placeholderCatchAll {
+		if start > 0 || end < len(segment)-1 {
+			return nil, false, fmt.Errorf("invalid placeholder in segment %s", segment)
+		}
+
+		return &routeParam{kind: placeholderCatchAll}, true, nil
+	}
+
+	name, kind, _ := strings.Cut(segment[start+1:end], ":")
+
+	switch kind {
+	case "":
+		kind = placeholderString
+	case placeholderString, placeholderInt, placeholderGUID:
+	default:
+		return nil, false, fmt.Errorf("unknown placeholder type %s", kind)
+	}
+
+	if name == "" {
+		return nil, false, fmt.Errorf("missing placeholder name in segment %s", segment)
+	}
+
+	return &routeParam{
+		name:   name,
+		kind:   kind,
+		prefix: segment[:start],
+		suffix: segment[end+1:],
+	}, true, nil
+}
+
+// openAPIPath drops the placeholder types of a template path.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+
+	for i, segment := range segments {
+		if param, ok, err := parsePlaceholder(segment); err == nil && ok && param.kind != placeholderCatchAll {
+			segments[i] = param.prefix + "{" + param.name + "}" + param.suffix
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (t *RouteTrie) insert(path string, route TemplateRoute) error {
+	node := t.root
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	var endpoints map[string]*RouteEndpoint
+	var params []*routeParam
+
+	for i, segment := range segments {
+		param, ok, err := parsePlaceholder(segment)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			key := strings.ToLower(segment)
+
+			if node.literals[key] == nil {
+				node.literals[key] = newRouteNode()
+			}
+
+			node = node.literals[key]
+			continue
+		}
+
+		if param.kind == placeholderCatchAll {
+			if i < len(segments)-1 {
+				return errors.New("catch-all placeholder before the end of the path")
+			}
+
+			if node.catchAll == nil {
+				node.catchAll = make(map[string]*RouteEndpoint)
+			}
+
+			endpoints = node.catchAll
+			break
+		}
+
+		param.index = i
+		params = append(params, param)
+
+		// Placeholders named differently share their branch, as the specs
+		// have them: /series/{id} and /series/{seriesId}/... The values are
+		// read from the path of the endpoint matched.
+		index := slices.IndexFunc(node.params, param.equals)
+		if index < 0 {
+			branch := *param
+			branch.node = newRouteNode()
+			node.params = append(node.params, &branch)
+
+			slices.SortStableFunc(node.params, compareParams)
+
+			node = branch.node
+		} else {
+			node = node.params[index].node
+		}
+	}
+
+	if endpoints == nil {
+		if node.endpoints == nil {
+			node.endpoints = make(map[string]*RouteEndpoint)
+		}
+
+		endpoints = node.endpoints
+	}
+
+	for _, method := range route.Methods {
+		method = strings.ToUpper(method)
+
+		if _, ok := endpoints[method]; ok {
+			return fmt.Errorf("duplicate endpoint %s %s", method, path)
+		}
+
+		endpoint := &RouteEndpoint{
+			Method: method,
+			Path:   path,
+			Route:  route,
+			params: params,
+		}
+
+		endpoints[method] = endpoint
+		t.endpoints = append(t.endpoints, endpoint)
+	}
+
+	return nil
+}
+
+// compileRoutes builds the trie of the endpoints of a service type. The
+// paths are matched in their canonical form, without empty segments. An
+// invalid endpoint is left out, the reasons are returned.
+func compileRoutes(endpoints map[string]TemplateRoute) (*RouteTrie, []error) {
+	t, _ := buildRoutes(endpoints, nil)
+
+	var paths []string
+	var errs []error
+
+	// Sorted for the conflicts to be reported the same way on each load, the
+	// first of two ambiguous endpoints is kept.
+	for _, path := range slices.Sorted(maps.Keys(endpoints)) {
+		switch {
+		case !strings.HasPrefix(path, "/"):
+			errs = append(errs, fmt.Errorf("invalid endpoint pattern %s: must start with /", path))
+			continue
+		case path != "/" && strings.HasSuffix(path, "/"):
+			errs = append(errs, fmt.Errorf("invalid endpoint pattern %s: must not end with /", path))
+			continue
+		case strings.Contains(path, "//"):
+			errs = append(errs, fmt.Errorf("invalid endpoint pattern %s: empty segment", path))
+			continue
+		}
+
+		// The ambiguities only show once the placeholders are resolved, the
+		// trie is built again with each endpoint.
+		trie, err := buildRoutes(endpoints, append(paths, path))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid endpoint pattern %s: %w", path, err))
+			continue
+		}
+
+		paths = append(paths, path)
+		t = trie
+	}
+
+	return t, errs
+}
+
+func buildRoutes(endpoints map[string]TemplateRoute, paths []string) (*RouteTrie, error) {
+	t := &RouteTrie{root: newRouteNode()}
+
+	for _, path := range paths {
+		if err := t.insert(path, endpoints[path]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.root.resolve(nil); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Endpoints lists the endpoints of the trie.
+func (t *RouteTrie) Endpoints() []*RouteEndpoint {
+	if t == nil {
+		return nil
+	}
+
+	return t.endpoints
+}
+
+func (t *RouteTrie) lookup(method string, segments []string) *RouteEndpoint {
+	node := t.root
+
+	for _, segment := range segments {
+		next := node.next(segment)
+		if next == nil {
+			return node.catchAll[method]
+		}
+
+		node = next
+	}
+
+	return node.endpoints[method]
+}
+
+// Match returns the endpoint allowing a request, nil when there is none. The
+// path is expected in its canonical form.
+func (t *RouteTrie) Match(method string, path string) *RouteMatch {
+	if t == nil {
+		return nil
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	endpoint := t.lookup(method, segments)
+	if endpoint == nil {
+		return nil
+	}
+
+	params := make(map[string]string, len(endpoint.params))
+	for _, param := range endpoint.params {
+		params[param.name], _ = param.matches(segments[param.index])
+	}
+
+	return &RouteMatch{
+		Endpoint: endpoint,
+		Params:   params,
+	}
+}
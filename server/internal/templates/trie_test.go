@@ -0,0 +1,243 @@
+package templates
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func routesOf(endpoints map[string]string) map[string]TemplateRoute {
+	routes := make(map[string]TemplateRoute, len(endpoints))
+	for path, methods := range endpoints {
+		routes[path] = TemplateRoute{Methods: strings.Split(methods, ",")}
+	}
+
+	return routes
+}
+
+func TestCompileRoutes(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints map[string]string
+		err       string
+	}{
+		{"root", map[string]string{"/": "GET"}, ""},
+		{"placeholders named differently", map[string]string{"/series/{id}": "GET", "/series/{seriesId}/folder": "GET"}, ""},
+		{"typed and untyped placeholders", map[string]string{"/tag/{id:int}": "GET", "/tag/{label}": "DELETE"}, ""},
+		{"placeholder and affixed placeholder", map[string]string{"/feed/{name}": "GET", "/feed/{name}.ics": "GET"}, ""},
+		{"disjoint affixes", map[string]string{"/feed/{name}.ics": "GET", "/feed/{name}.xml": "GET"}, ""},
+		{"int and prefixed placeholder", map[string]string{"/movie/{id:int}": "GET", "/movie/tt{imdbId}": "GET"}, ""},
+		{"int and guid", map[string]string{"/queue/{id:int}": "GET", "/queue/{id:guid}": "GET"}, ""},
+		{"missing leading slash", map[string]string{"series": "GET"}, "must start with /"},
+		{"trailing slash", map[string]string{"/series/": "GET"}, "must not end with /"},
+		{"empty segment", map[string]string{"/api//series": "GET"}, "empty segment"},
+		{"catch-all before the end", map[string]string{"/{*}/series": "GET"}, "catch-all placeholder before the end"},
+		{"catch-all with a prefix", map[string]string{"/cover-{*}": "GET"}, "invalid placeholder"},
+		{"unknown type", map[string]string{"/series/{id:uuid}": "GET"}, "unknown placeholder type"},
+		{"missing name", map[string]string{"/series/{:int}": "GET"}, "missing placeholder name"},
+		{"two placeholders in a segment", map[string]string{"/series/{a}-{b}": "GET"}, "invalid placeholder"},
+		{"duplicate endpoint", map[string]string{"/series/{id}": "GET", "/series/{seriesId}": "GET"}, "duplicate endpoint"},
+		{"duplicate endpoint in another case", map[string]string{"/Series": "GET", "/series": "GET"}, "duplicate endpoint"},
+		{"prefix and suffix overlapping", map[string]string{"/feed/{name}.ics": "GET", "/feed/cal{name}": "GET"}, "ambiguous placeholders"},
+		{"nested ambiguity", map[string]string{"/a/{x}/v{y}": "GET", "/a/{z}/{w:int}1": "GET"}, ""},
+		{"nested ambiguity below a merge", map[string]string{"/a/b/{x}.ics": "GET", "/a/{y}/cal{z}": "GET"}, "ambiguous placeholders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := compileRoutes(routesOf(tt.endpoints))
+			err := errors.Join(errs...)
+
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompileRoutesLeavesOut(t *testing.T) {
+	trie, errs := compileRoutes(routesOf(map[string]string{
+		"/api/v3/series":            "GET",
+		"/api/v3/series/":           "GET",
+		"/api/v3/tag/{a}-{b}":       "GET",
+		"/api/v3/movie":             "GET,GET",
+		"/feed/{name}.ics":          "GET",
+		"/feed/cal{name}":           "GET",
+		"/api/v3/calendar/{id:int}": "GET",
+	}))
+
+	want := []string{
+		"/api/v3/movie: duplicate endpoint",
+		"/api/v3/series/: must not end with /",
+		"/api/v3/tag/{a}-{b}: invalid placeholder",
+		"/feed/{name}.ics: ambiguous placeholders",
+	}
+
+	if len(errs) != len(want) {
+		t.Fatalf("errors %v, want %d", errs, len(want))
+	}
+
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("error %v, want %q", err, want[i])
+		}
+	}
+
+	tests := []struct {
+		path     string
+		endpoint string
+	}{
+		{"/api/v3/series", "/api/v3/series"},
+		{"/api/v3/calendar/1", "/api/v3/calendar/{id:int}"},
+		{"/feed/calendar", "/feed/cal{name}"},
+		{"/feed/calendar.ics", "/feed/cal{name}"},
+		{"/api/v3/tag/a-b", ""},
+		{"/api/v3/movie", ""},
+	}
+
+	for _, tt := range tests {
+		match := trie.Match("GET", tt.path)
+
+		switch {
+		case tt.endpoint == "" && match != nil:
+			t.Errorf("GET %s matched the left out %s", tt.path, match.Endpoint.Path)
+		case tt.endpoint != "" && (match == nil || match.Endpoint.Path != tt.endpoint):
+			t.Errorf("GET %s matched %v, want %s", tt.path, match, tt.endpoint)
+		}
+	}
+
+	// Without a valid endpoint the trie matches nothing.
+	trie, errs = compileRoutes(routesOf(map[string]string{"series": "GET"}))
+	if len(errs) != 1 || trie.Match("GET", "/series") != nil || len(trie.Endpoints()) != 0 {
+		t.Errorf("trie %v, errors %v", trie.Endpoints(), errs)
+	}
+}
+
+func TestRouteTrieMatch(t *testing.T) {
+	trie, errs := compileRoutes(routesOf(map[string]string{
+		"/":                                  "GET",
+		"/api/v3/series":                     "GET,POST",
+		"/api/v3/series/{id:int}":            "GET,PUT",
+		"/api/v3/series/editor":              "PUT",
+		"/api/v3/series/lookup":              "GET",
+		"/api/v3/series/{slug}/folder":       "GET",
+		"/api/v3/series/{seriesId}/episodes": "GET",
+		"/api/v3/tag/{id:int}":               "GET",
+		"/api/v3/tag/{label}":                "DELETE",
+		"/api/v3/queue/{id:guid}":            "DELETE",
+		"/MediaCover/{*}":                    "GET",
+		"/MediaCover/{seriesId:int}/poster-{size:int}.jpg": "GET",
+		"/feed/v3/calendar/{name}.ics":                     "GET",
+	}))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		endpoint string
+		params   map[string]string
+	}{
+		{"root", "GET", "/", "/", nil},
+		{"literal", "GET", "/api/v3/series", "/api/v3/series", nil},
+		{"literal in another case", "POST", "/API/V3/Series", "/api/v3/series", nil},
+		{"method not allowed", "DELETE", "/api/v3/series", "", nil},
+		{"int placeholder", "GET", "/api/v3/series/12", "/api/v3/series/{id:int}", map[string]string{"id": "12"}},
+		{"negative int", "PUT", "/api/v3/series/-12", "/api/v3/series/{id:int}", map[string]string{"id": "-12"}},
+		{"literal over a placeholder", "PUT", "/api/v3/series/editor", "/api/v3/series/editor", nil},
+		{"literal without the method", "GET", "/api/v3/series/editor", "", nil},
+		{"untyped placeholder without endpoint", "GET", "/api/v3/series/abc", "", nil},
+		{"literal falling through to a placeholder", "GET", "/api/v3/series/lookup/folder", "/api/v3/series/{slug}/folder", map[string]string{"slug": "lookup"}},
+		{"int falling through to a string", "GET", "/api/v3/series/12/folder", "/api/v3/series/{slug}/folder", map[string]string{"slug": "12"}},
+		{"placeholder named differently", "GET", "/api/v3/series/12/episodes", "/api/v3/series/{seriesId}/episodes", map[string]string{"seriesId": "12"}},
+		{"path too long", "GET", "/api/v3/series/12/folder/x", "", nil},
+		{"typed method", "GET", "/api/v3/tag/5", "/api/v3/tag/{id:int}", map[string]string{"id": "5"}},
+		{"untyped method on an int", "DELETE", "/api/v3/tag/5", "/api/v3/tag/{label}", map[string]string{"label": "5"}},
+		{"untyped method", "DELETE", "/api/v3/tag/anime", "/api/v3/tag/{label}", map[string]string{"label": "anime"}},
+		{"guid", "DELETE", "/api/v3/queue/0B3C2A6E-94A1-4F3A-8D1C-6A7B2E9F0D41", "/api/v3/queue/{id:guid}", map[string]string{"id": "0B3C2A6E-94A1-4F3A-8D1C-6A7B2E9F0D41"}},
+		{"not a guid", "DELETE", "/api/v3/queue/123", "", nil},
+		{"affixed placeholders", "GET", "/MediaCover/1/poster-500.jpg", "/MediaCover/{seriesId:int}/poster-{size:int}.jpg", map[string]string{"seriesId": "1", "size": "500"}},
+		{"catch-all past a placeholder", "GET", "/MediaCover/1/fanart.jpg", "/MediaCover/{*}", nil},
+		{"catch-all past a failed affix", "GET", "/MediaCover/1/poster-big.jpg", "/MediaCover/{*}", nil},
+		{"catch-all ending on a placeholder", "GET", "/mediacover/1", "/MediaCover/{*}", nil},
+		{"catch-all deeper", "GET", "/MediaCover/a/b/c", "/MediaCover/{*}", nil},
+		{"catch-all without segment", "GET", "/MediaCover", "", nil},
+		{"catch-all method not allowed", "HEAD", "/MediaCover/1/poster-500.jpg", "", nil},
+		{"suffix", "GET", "/feed/v3/calendar/Sonarr.ICS", "/feed/v3/calendar/{name}.ics", map[string]string{"name": "Sonarr"}},
+		{"empty value", "GET", "/feed/v3/calendar/.ics", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := trie.Match(tt.method, tt.path)
+
+			if tt.endpoint == "" {
+				if match != nil {
+					t.Fatalf("%s %s matched %s", tt.method, tt.path, match.Endpoint.Path)
+				}
+
+				return
+			}
+
+			if match == nil {
+				t.Fatalf("%s %s matched nothing, want %s", tt.method, tt.path, tt.endpoint)
+			}
+
+			if match.Endpoint.Path != tt.endpoint || match.Endpoint.Method != tt.method {
+				t.Errorf("matched %s %s, want %s %s", match.Endpoint.Method, match.Endpoint.Path, tt.method, tt.endpoint)
+			}
+
+			if !maps.Equal(match.Params, tt.params) {
+				t.Errorf("params %v, want %v", match.Params, tt.params)
+			}
+		})
+	}
+
+	if got := len(trie.Endpoints()); got != 15 {
+		t.Errorf("%d endpoints, want 15", got)
+	}
+}
+
+func TestRouteParamRelations(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		within   bool
+		overlaps bool
+	}{
+		{"{id:int}", "{name}", true, true},
+		{"{id:guid}", "{name}", true, true},
+		{"{name}", "{id:int}", false, true},
+		{"{id:int}", "{id:guid}", false, false},
+		{"{name}.ics", "{name}", true, true},
+		{"cal{name}", "{name}", true, true},
+		{"{name}.ics", "{name}.xml", false, false},
+		{"{name}.ics", "cal{name}", false, true},
+		{"tt{id}", "{id:int}", false, false},
+		{"{id:int}.jpg", "{id:int}", false, false},
+		{"1{id:int}", "{id:int}", false, true},
+		{"-{id:int}", "{id:int}", false, true},
+		{"{id:int}g", "{id:guid}", false, false},
+		{"A{name}", "a{name}", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			a, _, _ := parsePlaceholder(tt.a)
+			b, _, _ := parsePlaceholder(tt.b)
+
+			if got := a.within(b); got != tt.within {
+				t.Errorf("within %v, want %v", got, tt.within)
+			}
+
+			if got := a.overlaps(b); got != tt.overlaps {
+				t.Errorf("overlaps %v, want %v", got, tt.overlaps)
+			}
+		})
+	}
+}
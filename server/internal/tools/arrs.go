@@ -3,8 +3,12 @@ package tools
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/middlewarr/server/internal/models"
 )
@@ -24,9 +28,21 @@ type ServiceSystemStatusResponse struct {
 }
 
 type ServiceOpenAPISpec struct {
-	Paths map[string]map[string]any `json:"paths"` // method, operation
+	Paths      map[string]map[string]json.RawMessage `json:"paths"` // method, operation
+	Components OpenAPIComponents                     `json:"components"`
 }
 
+// The specs are downloaded once a day, the last ones are kept when GitHub
+// cannot be reached.
+const openAPISpecsTTL = 24 * time.Hour
+
+type cachedOpenAPISpec struct {
+	spec      *ServiceOpenAPISpec
+	fetchedAt time.Time
+}
+
+var openAPISpecs sync.Map
+
 func ValidateServiceType(serviceType string) error {
 	s := ServiceType(serviceType)
 
@@ -56,9 +72,48 @@ func getServiceHealthPath(serviceType string) string {
 	return ""
 }
 
+// Response members holding credentials: the service host settings and the
+// fields of download clients, indexers, notifications and import lists.
+var sensitiveFields = []string{
+	"apiKey",
+	"password",
+	"passwordConfirmation",
+	"proxyPassword",
+	"sslCertPassword",
+	"passkey",
+	"cookie",
+	"token",
+	"accessToken",
+	"accessTokenSecret",
+	"refreshToken",
+	"authToken",
+	"botToken",
+	"appToken",
+	"userKey",
+	"secretToken",
+	"consumerSecret",
+	"clientSecret",
+	"webHookUrl",
+}
+
+// GetSensitiveFields returns the response members masked for a service type,
+// Prowlarr also holds the credentials of the trackers.
+func GetSensitiveFields(serviceType string) []string {
+	s := ServiceType(serviceType)
+
+	switch s {
+	case Prowlarr:
+		return append(slices.Clone(sensitiveFields), "rssKey", "mamId")
+	default:
+		return sensitiveFields
+	}
+}
+
 func ValidateServiceHealth(service models.Service) error {
 	l := GetLogger()
-	client := http.Client{}
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
 
 	healthCheckUrl := service.URL + getServiceHealthPath(service.Type)
 
@@ -74,8 +129,19 @@ func ValidateServiceHealth(service models.Service) error {
 		return err
 	}
 
+	apiKey, err := DecryptSecret(service.APIKey)
+	if err != nil {
+		l.Error().
+			Err(err).
+			Str("service_name", service.Name).
+			Str("service_type", service.Type).
+			Msg("Cannot decrypt the service API key")
+
+		return err
+	}
+
 	req.Header = http.Header{
-		"X-Api-Key": {service.APIKey},
+		"X-Api-Key": {apiKey},
 	}
 
 	res, err := client.Do(req)
@@ -88,18 +154,18 @@ func ValidateServiceHealth(service models.Service) error {
 		return err
 	}
 
+	defer res.Body.Close()
+
 	if res.StatusCode == http.StatusUnauthorized {
 		l.Warn().
 			Str("service_name", service.Name).
 			Str("service_type", service.Type).
 			Str("service_url", service.URL).
 			Msg("Invalid service API key")
-		return err
+		return errors.New("invalid service API key")
 	}
 
 	if res.StatusCode == http.StatusOK {
-		defer res.Body.Close()
-
 		body, err := io.ReadAll(res.Body)
 		if err != nil {
 			l.Warn().
@@ -127,7 +193,7 @@ func ValidateServiceHealth(service models.Service) error {
 				Str("service_type", service.Type).
 				Str("service_url", service.URL).
 				Msg("Cannot retrieve service version")
-			return err
+			return errors.New("cannot retrieve service version")
 		}
 
 		l.Info().
@@ -143,6 +209,7 @@ func ValidateServiceHealth(service models.Service) error {
 			Str("service_type", service.Type).
 			Str("service_url", service.URL).
 			Msg("Service not healty")
+		return fmt.Errorf("service not healthy, status %s", res.Status)
 	}
 
 	return nil
@@ -168,6 +235,33 @@ func getOpenAPISpecsURL(serviceType string) string {
 func GetOpenAPISpecs(serviceType string) (*ServiceOpenAPISpec, error) {
 	l := GetLogger()
 
+	cached, ok := openAPISpecs.Load(serviceType)
+	if ok && time.Since(cached.(cachedOpenAPISpec).fetchedAt) < openAPISpecsTTL {
+		return cached.(cachedOpenAPISpec).spec, nil
+	}
+
+	spec, err := fetchOpenAPISpecs(serviceType)
+	if err != nil {
+		if ok {
+			l.Warn().
+				Err(err).
+				Str("service_type", serviceType).
+				Msg("Using previously downloaded OpenAPI specs")
+
+			return cached.(cachedOpenAPISpec).spec, nil
+		}
+
+		return nil, err
+	}
+
+	openAPISpecs.Store(serviceType, cachedOpenAPISpec{spec, time.Now()})
+
+	return spec, nil
+}
+
+func fetchOpenAPISpecs(serviceType string) (*ServiceOpenAPISpec, error) {
+	l := GetLogger()
+
 	specsURL := getOpenAPISpecsURL(serviceType)
 	if specsURL == "" {
 		l.Error().
@@ -186,6 +280,13 @@ func GetOpenAPISpecs(serviceType string) (*ServiceOpenAPISpec, error) {
 
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		l.Error().
+			Str("status", res.Status).
+			Msg("cannot get OpenAPI specs")
+		return nil, fmt.Errorf("cannot get OpenAPI specs, status %s", res.Status)
+	}
+
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		l.Error().
@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"fmt"
+	"slices"
+)
+
+type AuthScheme string
+
+const (
+	AuthSchemeHeader AuthScheme = "header"
+	AuthSchemeQuery  AuthScheme = "query"
+	AuthSchemeBearer AuthScheme = "bearer"
+	AuthSchemeBasic  AuthScheme = "basic"
+	AuthSchemePath   AuthScheme = "path"
+)
+
+// Schemes enabled for the apps which do not select any.
+var DefaultAuthSchemes = []string{string(AuthSchemeHeader), string(AuthSchemeQuery)}
+
+func ValidateAuthSchemes(schemes []string) error {
+	for _, scheme := range schemes {
+		switch AuthScheme(scheme) {
+		case AuthSchemeHeader, AuthSchemeQuery, AuthSchemeBearer, AuthSchemeBasic, AuthSchemePath:
+		default:
+			return fmt.Errorf("invalid auth scheme %s", scheme)
+		}
+	}
+
+	return nil
+}
+
+func IsAuthSchemeEnabled(schemes []string, scheme AuthScheme) bool {
+	if len(schemes) == 0 {
+		schemes = DefaultAuthSchemes
+	}
+
+	return slices.Contains(schemes, string(scheme))
+}
@@ -0,0 +1,49 @@
+package tools
+
+import "testing"
+
+func TestValidateAuthSchemes(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemes []string
+		valid   bool
+	}{
+		{"none", nil, true},
+		{"all", []string{"header", "query", "bearer", "basic", "path"}, true},
+		{"unknown", []string{"header", "cookie"}, false},
+		{"case sensitive", []string{"Bearer"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateAuthSchemes(tt.schemes); (err == nil) != tt.valid {
+				t.Errorf("ValidateAuthSchemes(%v) = %v, want valid %v", tt.schemes, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestIsAuthSchemeEnabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemes []string
+		scheme  AuthScheme
+		enabled bool
+	}{
+		{"default header", nil, AuthSchemeHeader, true},
+		{"default query", nil, AuthSchemeQuery, true},
+		{"default bearer", nil, AuthSchemeBearer, false},
+		{"default path", []string{}, AuthSchemePath, false},
+		{"selected", []string{"bearer", "path"}, AuthSchemePath, true},
+		{"not selected", []string{"bearer"}, AuthSchemeHeader, false},
+		{"no key", []string{"bearer"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if enabled := IsAuthSchemeEnabled(tt.schemes, tt.scheme); enabled != tt.enabled {
+				t.Errorf("IsAuthSchemeEnabled(%v, %q) = %v, want %v", tt.schemes, tt.scheme, enabled, tt.enabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package tools
+
+import "errors"
+
+type ServiceBalancing string
+
+const (
+	PrimaryBackup    ServiceBalancing = "primary_backup"
+	RoundRobin       ServiceBalancing = "round_robin"
+	LeastConnections ServiceBalancing = "least_connections"
+)
+
+func ValidateServiceBalancing(balancing string) error {
+	switch ServiceBalancing(balancing) {
+	case "", PrimaryBackup, RoundRobin, LeastConnections:
+		return nil
+	default:
+		return errors.New("invalid service balancing")
+	}
+}
@@ -0,0 +1,41 @@
+package tools
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseCIDRs parses a list of CIDR ranges, plain addresses match themselves.
+func ParseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %s", cidr)
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s", cidr)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func ValidateCIDRs(cidrs []string) error {
+	_, err := ParseCIDRs(cidrs)
+
+	return err
+}
@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidrs    []string
+		prefixes []string
+		valid    bool
+	}{
+		{"none", nil, nil, true},
+		{"range", []string{"10.0.0.0/8"}, []string{"10.0.0.0/8"}, true},
+		{"masked", []string{"10.1.2.3/8"}, []string{"10.0.0.0/8"}, true},
+		{"address", []string{" 192.0.2.1 "}, []string{"192.0.2.1/32"}, true},
+		{"mapped address", []string{"::ffff:192.0.2.1"}, []string{"192.0.2.1/32"}, true},
+		{"ipv6", []string{"2001:db8::/32", "::1"}, []string{"2001:db8::/32", "::1/128"}, true},
+		{"invalid address", []string{"10.0.0.256"}, nil, false},
+		{"invalid bits", []string{"10.0.0.0/33"}, nil, false},
+		{"hostname", []string{"localhost"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixes, err := ParseCIDRs(tt.cidrs)
+			if (err == nil) != tt.valid {
+				t.Fatalf("ParseCIDRs(%v) = %v, want valid %v", tt.cidrs, err, tt.valid)
+			}
+
+			var got []string
+			for _, prefix := range prefixes {
+				got = append(got, prefix.String())
+			}
+
+			if !slices.Equal(got, tt.prefixes) {
+				t.Errorf("ParseCIDRs(%v) = %v, want %v", tt.cidrs, got, tt.prefixes)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Nested $ref chains longer than this are considered broken.
+const maxSchemaRefDepth int = 32
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+type OpenAPIOperation struct {
+	Parameters  []OpenAPIParameter  `json:"parameters"`
+	RequestBody *OpenAPIRequestBody `json:"requestBody"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPISchema struct {
+	Ref                  string                       `json:"$ref"`
+	Type                 string                       `json:"type"`
+	Format               string                       `json:"format"`
+	Nullable             bool                         `json:"nullable"`
+	Enum                 []any                        `json:"enum"`
+	Items                *OpenAPISchema               `json:"items"`
+	Properties           map[string]*OpenAPISchema    `json:"properties"`
+	Required             []string                     `json:"required"`
+	AdditionalProperties *OpenAPIAdditionalProperties `json:"additionalProperties"`
+	AllOf                []*OpenAPISchema             `json:"allOf"`
+	OneOf                []*OpenAPISchema             `json:"oneOf"`
+	AnyOf                []*OpenAPISchema             `json:"anyOf"`
+}
+
+// OpenAPIAdditionalProperties is either a boolean or the schema of the
+// additional properties.
+type OpenAPIAdditionalProperties struct {
+	Allowed bool
+	Schema  *OpenAPISchema
+}
+
+func (a *OpenAPIAdditionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+
+	a.Allowed = true
+
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// SchemaError locates the first value of a request not matching the schema.
+type SchemaError struct {
+	Pointer string `json:"pointer"`
+	Rule    string `json:"rule"`
+}
+
+func (e *SchemaError) Error() string {
+	if e.Pointer == "" {
+		return e.Rule
+	}
+
+	return e.Pointer + " " + e.Rule
+}
+
+var ErrOperationNotFound = errors.New("operation not found in the OpenAPI specs")
+
+// Operation returns the operation of a path, ErrOperationNotFound when the
+// specs do not have it.
+func (s *ServiceOpenAPISpec) Operation(path string, method string) (*OpenAPIOperation, error) {
+	raw, ok := s.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return nil, ErrOperationNotFound
+	}
+
+	var operation OpenAPIOperation
+	if err := json.Unmarshal(raw, &operation); err != nil {
+		return nil, err
+	}
+
+	return &operation, nil
+}
+
+// JSONSchema returns the schema of the JSON content of the request body.
+func (b *OpenAPIRequestBody) JSONSchema() *OpenAPISchema {
+	if b == nil {
+		return nil
+	}
+
+	if content, ok := b.Content["application/json"]; ok {
+		return content.Schema
+	}
+
+	for mediaType, content := range b.Content {
+		if strings.Contains(mediaType, "json") {
+			return content.Schema
+		}
+	}
+
+	return nil
+}
+
+func (s *ServiceOpenAPISpec) resolveSchema(schema *OpenAPISchema) (*OpenAPISchema, error) {
+	for range maxSchemaRefDepth {
+		if schema == nil || schema.Ref == "" {
+			return schema, nil
+		}
+
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		if !ok {
+			return nil, fmt.Errorf("unsupported schema reference %s", schema.Ref)
+		}
+
+		schema, ok = s.Components.Schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown schema reference %s", name)
+		}
+	}
+
+	return nil, fmt.Errorf("schema references too deep")
+}
+
+func findProperty(properties map[string]*OpenAPISchema, key string) (*OpenAPISchema, bool) {
+	if property, ok := properties[key]; ok {
+		return property, true
+	}
+
+	// The services bind the properties regardless of their case.
+	for name, property := range properties {
+		if strings.EqualFold(name, key) {
+			return property, true
+		}
+	}
+
+	return nil, false
+}
+
+func matchEnum(enum []any, value any) bool {
+	for _, e := range enum {
+		if s, ok := value.(string); ok {
+			if es, ok := e.(string); ok && strings.EqualFold(s, es) {
+				return true
+			}
+
+			continue
+		}
+
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateSchema checks a decoded JSON value against a schema of the specs.
+func (s *ServiceOpenAPISpec) ValidateSchema(schema *OpenAPISchema, value any, pointer string) *SchemaError {
+	schema, err := s.resolveSchema(schema)
+	if err != nil || schema == nil {
+		return nil
+	}
+
+	for _, sub := range schema.AllOf {
+		if err := s.ValidateSchema(sub, value, pointer); err != nil {
+			return err
+		}
+	}
+
+	for _, alternatives := range [][]*OpenAPISchema{schema.OneOf, schema.AnyOf} {
+		if len(alternatives) == 0 {
+			continue
+		}
+
+		var first *SchemaError
+		for _, sub := range alternatives {
+			err := s.ValidateSchema(sub, value, pointer)
+			if err == nil {
+				first = nil
+				break
+			}
+
+			if first == nil {
+				first = err
+			}
+		}
+
+		if first != nil {
+			return first
+		}
+	}
+
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" {
+			return &SchemaError{pointer, "must not be null"}
+		}
+
+		return nil
+	}
+
+	if len(schema.Enum) > 0 && !matchEnum(schema.Enum, value) {
+		values := make([]string, len(schema.Enum))
+		for i, e := range schema.Enum {
+			values[i] = fmt.Sprint(e)
+		}
+
+		return &SchemaError{pointer, "must be one of " + strings.Join(values, ", ")}
+	}
+
+	switch schema.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return &SchemaError{pointer, "must be a string"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return &SchemaError{pointer, "must be a boolean"}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return &SchemaError{pointer, "must be a number"}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return &SchemaError{pointer, "must be an integer"}
+		}
+
+		if schema.Format == "int32" && (n < math.MinInt32 || n > math.MaxInt32) {
+			return &SchemaError{pointer, "must be a 32-bit integer"}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return &SchemaError{pointer, "must be an array"}
+		}
+
+		for i, item := range items {
+			if err := s.ValidateSchema(schema.Items, item, pointer+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	case "object", "":
+		object, ok := value.(map[string]any)
+		if !ok {
+			if schema.Type == "object" {
+				return &SchemaError{pointer, "must be an object"}
+			}
+
+			return nil
+		}
+
+		for _, name := range schema.Required {
+			found := false
+			for key := range object {
+				found = found || strings.EqualFold(key, name)
+			}
+
+			if !found {
+				return &SchemaError{pointer + "/" + name, "is required"}
+			}
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(object)) {
+			child := object[key]
+			childPointer := pointer + "/" + strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+
+			property, ok := findProperty(schema.Properties, key)
+			if !ok {
+				additional := schema.AdditionalProperties
+				if additional == nil {
+					continue
+				}
+
+				if !additional.Allowed {
+					return &SchemaError{childPointer, "is not allowed"}
+				}
+
+				property = additional.Schema
+			}
+
+			if err := s.ValidateSchema(property, child, childPointer); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateParameter checks the raw values of a path or query parameter.
+func (s *ServiceOpenAPISpec) ValidateParameter(schema *OpenAPISchema, values []string) *SchemaError {
+	schema, err := s.resolveSchema(schema)
+	if err != nil || schema == nil {
+		return nil
+	}
+
+	if schema.Type == "array" {
+		items := make([]any, 0, len(values))
+		for _, value := range values {
+			for _, v := range strings.Split(value, ",") {
+				items = append(items, s.parseParameter(schema.Items, v))
+			}
+		}
+
+		return s.ValidateSchema(schema, items, "")
+	}
+
+	for _, value := range values {
+		if err := s.ValidateSchema(schema, s.parseParameter(schema, value), ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseParameter converts a raw value to the JSON type of the schema, the
+// value stays a string when it cannot be converted so the type check fails.
+func (s *ServiceOpenAPISpec) parseParameter(schema *OpenAPISchema, value string) any {
+	schema, err := s.resolveSchema(schema)
+	if err != nil || schema == nil {
+		return value
+	}
+
+	// The services bind integers from decimal digits only, "1e3" is not one.
+	switch schema.Type {
+	case "integer":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return float64(n)
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+			return n
+		}
+	case "boolean":
+		if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+			return strings.EqualFold(value, "true")
+		}
+	}
+
+	return value
+}
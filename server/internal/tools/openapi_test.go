@@ -0,0 +1,176 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestOperation(t *testing.T) {
+	var specs ServiceOpenAPISpec
+	err := json.Unmarshal([]byte(`{"paths": {
+		"/api/v3/series/{id}": {"get": {"parameters": [{"name": "id", "in": "path"}]}},
+		"/api/v3/broken": {"get": []}
+	}}`), &specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		method string
+		params int
+		err    error
+	}{
+		{"found", "/api/v3/series/{id}", "GET", 1, nil},
+		{"method in another case", "/api/v3/series/{id}", "get", 1, nil},
+		{"missing method", "/api/v3/series/{id}", "DELETE", 0, ErrOperationNotFound},
+		{"missing path", "/api/v3/system/backup", "GET", 0, ErrOperationNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation, err := specs.Operation(tt.path, tt.method)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+
+			if err == nil && len(operation.Parameters) != tt.params {
+				t.Errorf("%d parameters, want %d", len(operation.Parameters), tt.params)
+			}
+		})
+	}
+
+	if _, err := specs.Operation("/api/v3/broken", "GET"); err == nil || errors.Is(err, ErrOperationNotFound) {
+		t.Errorf("invalid operation error %v", err)
+	}
+}
+
+const validationSpecs = `{
+	"paths": {},
+	"components": {
+		"schemas": {
+			"Series": {
+				"type": "object",
+				"required": ["title"],
+				"properties": {
+					"title": {"type": "string"},
+					"tvdbId": {"type": "integer", "format": "int32"},
+					"monitored": {"type": "boolean"},
+					"ratings": {"$ref": "#/components/schemas/Ratings"},
+					"seasons": {"type": "array", "items": {"$ref": "#/components/schemas/Season"}},
+					"seriesType": {"$ref": "#/components/schemas/SeriesType"},
+					"overview": {"type": "string", "nullable": true}
+				}
+			},
+			"Ratings": {"type": "object", "properties": {"value": {"type": "number"}}},
+			"Season": {"type": "object", "properties": {"seasonNumber": {"type": "integer"}}, "additionalProperties": false},
+			"SeriesType": {"type": "string", "enum": ["standard", "daily", "anime"]},
+			"Tags": {"type": "object", "additionalProperties": {"type": "integer"}},
+			"Id": {"oneOf": [{"type": "integer"}, {"type": "string"}]},
+			"Loop": {"$ref": "#/components/schemas/Loop"}
+		}
+	}
+}`
+
+func TestValidateSchema(t *testing.T) {
+	var specs ServiceOpenAPISpec
+	if err := json.Unmarshal([]byte(validationSpecs), &specs); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		err    *SchemaError
+	}{
+		{"valid", "Series", `{"title": "Firefly", "tvdbId": 78874, "monitored": true}`, nil},
+		{"required", "Series", `{"tvdbId": 78874}`, &SchemaError{"/title", "is required"}},
+		{"required any case", "Series", `{"Title": "Firefly"}`, nil},
+		{"string", "Series", `{"title": 1}`, &SchemaError{"/title", "must be a string"}},
+		{"integer", "Series", `{"title": "Firefly", "tvdbId": 1.5}`, &SchemaError{"/tvdbId", "must be an integer"}},
+		{"int32", "Series", `{"title": "Firefly", "tvdbId": 4294967296}`, &SchemaError{"/tvdbId", "must be a 32-bit integer"}},
+		{"boolean", "Series", `{"title": "Firefly", "monitored": "yes"}`, &SchemaError{"/monitored", "must be a boolean"}},
+		{"null", "Series", `{"title": null}`, &SchemaError{"/title", "must not be null"}},
+		{"nullable", "Series", `{"title": "Firefly", "overview": null}`, nil},
+		{"unknown property", "Series", `{"title": "Firefly", "path": "/tv"}`, nil},
+		{"nested reference", "Series", `{"title": "Firefly", "ratings": {"value": "high"}}`, &SchemaError{"/ratings/value", "must be a number"}},
+		{"array", "Series", `{"title": "Firefly", "seasons": {}}`, &SchemaError{"/seasons", "must be an array"}},
+		{"array item", "Series", `{"title": "Firefly", "seasons": [{"seasonNumber": 1}, {"seasonNumber": "2"}]}`, &SchemaError{"/seasons/1/seasonNumber", "must be an integer"}},
+		{"additional properties refused", "Season", `{"seasonNumber": 1, "monitored": true}`, &SchemaError{"/monitored", "is not allowed"}},
+		{"additional properties schema", "Tags", `{"a": 1, "b/c": "2"}`, &SchemaError{"/b~1c", "must be an integer"}},
+		{"enum", "SeriesType", `"anime"`, nil},
+		{"enum any case", "SeriesType", `"Anime"`, nil},
+		{"not in enum", "SeriesType", `"movie"`, &SchemaError{"", "must be one of standard, daily, anime"}},
+		{"one of first", "Id", `1`, nil},
+		{"one of second", "Id", `"tt0303461"`, nil},
+		{"one of none", "Id", `true`, &SchemaError{"", "must be an integer"}},
+		{"object", "Series", `[]`, &SchemaError{"", "must be an object"}},
+		{"reference loop", "Loop", `1`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			err := specs.ValidateSchema(&OpenAPISchema{Ref: "#/components/schemas/" + tt.schema}, value, "")
+
+			switch {
+			case err == nil && tt.err == nil:
+			case err == nil || tt.err == nil || *err != *tt.err:
+				t.Errorf("ValidateSchema() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateParameter(t *testing.T) {
+	var specs ServiceOpenAPISpec
+	if err := json.Unmarshal([]byte(validationSpecs), &specs); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		schema string
+		values []string
+		valid  bool
+	}{
+		{"integer", `{"type": "integer"}`, []string{"42"}, true},
+		{"negative integer", `{"type": "integer"}`, []string{"-42"}, true},
+		{"fraction", `{"type": "integer"}`, []string{"4.2"}, false},
+		{"exponent", `{"type": "integer"}`, []string{"1e3"}, false},
+		{"hexadecimal", `{"type": "integer"}`, []string{"0x10"}, false},
+		{"integer overflow", `{"type": "integer"}`, []string{"99999999999999999999"}, false},
+		{"int32 overflow", `{"type": "integer", "format": "int32"}`, []string{"2147483648"}, false},
+		{"number", `{"type": "number"}`, []string{"4.2"}, true},
+		{"not a number", `{"type": "number"}`, []string{"NaN"}, false},
+		{"infinite", `{"type": "number"}`, []string{"Inf"}, false},
+		{"boolean", `{"type": "boolean"}`, []string{"True"}, true},
+		{"not a boolean", `{"type": "boolean"}`, []string{"1"}, false},
+		{"every value", `{"type": "integer"}`, []string{"1", "x"}, false},
+		{"array", `{"type": "array", "items": {"type": "integer"}}`, []string{"1,2", "3"}, true},
+		{"array item", `{"type": "array", "items": {"type": "integer"}}`, []string{"1,x"}, false},
+		{"reference", `{"$ref": "#/components/schemas/SeriesType"}`, []string{"daily"}, true},
+		{"reference enum", `{"$ref": "#/components/schemas/SeriesType"}`, []string{"movie"}, false},
+		{"unknown reference", `{"$ref": "#/components/schemas/Missing"}`, []string{"x"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema OpenAPISchema
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := specs.ValidateParameter(&schema, tt.values); (err == nil) != tt.valid {
+				t.Errorf("ValidateParameter(%v) = %v, want valid %v", tt.values, err, tt.valid)
+			}
+		})
+	}
+}
@@ -1,17 +1,48 @@
 package tools
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+)
+
+// keyPathPrefix starts the paths carrying the API key, `/k/{key}/...`.
+const keyPathPrefix = "/k/"
 
 func SanitizeURI(r *http.Request) string {
 	url := *r.URL
 
 	query := url.Query()
-	if apiKey := query.Get("apikey"); apiKey != "" {
-		redactedApiKey := apiKey[:7] + "..." + apiKey[len(apiKey)-7:]
-		query.Set("apikey", redactedApiKey)
 
-		url.RawQuery = query.Encode()
+	for _, key := range []string{"apikey", "access_token"} {
+		if apiKey := query.Get(key); apiKey != "" {
+			query.Set(key, redactApiKey(apiKey))
+
+			url.RawQuery = query.Encode()
+		}
+	}
+
+	uri := url.RequestURI()
+
+	// The key segment is only stripped once the request is authenticated,
+	// requests refused before still carry it.
+	if strings.HasPrefix(uri, keyPathPrefix) {
+		key := uri[len(keyPathPrefix):]
+		rest := ""
+
+		if i := strings.IndexAny(key, "/?"); i >= 0 {
+			key, rest = key[:i], key[i:]
+		}
+
+		uri = keyPathPrefix + redactApiKey(key) + rest
+	}
+
+	return uri
+}
+
+func redactApiKey(apiKey string) string {
+	if len(apiKey) < 16 {
+		return "..."
 	}
 
-	return url.RequestURI()
+	return apiKey[:7] + "..." + apiKey[len(apiKey)-7:]
 }
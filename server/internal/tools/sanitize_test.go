@@ -0,0 +1,34 @@
+package tools
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSanitizeURI(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		uri    string
+	}{
+		{"no key", "/api/v3/series?tvdbId=1", "/api/v3/series?tvdbId=1"},
+		{"query key", "/api/v3/series?apikey=client0123456789abcdef", "/api/v3/series?apikey=client0...9abcdef"},
+		{"access token", "/signalr/messages?access_token=client0123456789abcdef", "/signalr/messages?access_token=client0...9abcdef"},
+		{"short key", "/api/v3/series?apikey=short", "/api/v3/series?apikey=..."},
+		{"path key", "/k/client0123456789abcdef/api/v3/series", "/k/client0...9abcdef/api/v3/series"},
+		{"path key without a path", "/k/client0123456789abcdef", "/k/client0...9abcdef"},
+		{"path key before a query", "/k/client0123456789abcdef?tvdbId=1", "/k/client0...9abcdef?tvdbId=1"},
+		{"path key and query key", "/k/client0123456789abcdef/api?apikey=client0123456789abcdef", "/k/client0...9abcdef/api?apikey=client0...9abcdef"},
+		{"short path key", "/k/short/api/v3/series", "/k/.../api/v3/series"},
+		{"escaped path key", "/k/client0123456789%2Fabcdef/api", "/k/client0...Fabcdef/api"},
+		{"other path", "/api/k/client0123456789abcdef", "/api/k/client0123456789abcdef"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if uri := SanitizeURI(httptest.NewRequest("GET", tt.target, nil)); uri != tt.uri {
+				t.Errorf("SanitizeURI(%s) = %s, want %s", tt.target, uri, tt.uri)
+			}
+		})
+	}
+}
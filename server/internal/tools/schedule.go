@@ -0,0 +1,148 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// Time zones are not available in the container image.
+	_ "time/tzdata"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+// The days are given by their English name or its first three letters.
+var scheduleDays = func() map[string]time.Weekday {
+	days := make(map[string]time.Weekday)
+
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+
+		days[name] = day
+		days[name[:3]] = day
+	}
+
+	return days
+}()
+
+// The end of the day, which "15:04" cannot be parsed into.
+const scheduleMidnight = "24:00"
+
+type scheduleWindow struct {
+	days  map[time.Weekday]bool
+	start int
+	end   int
+}
+
+// Schedule is the parsed form of an app schedule, times are minutes since
+// midnight in the schedule time zone.
+type Schedule struct {
+	location *time.Location
+	windows  []scheduleWindow
+}
+
+func parseScheduleTime(s string) (int, error) {
+	if s == scheduleMidnight {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule time %s", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func ParseAppSchedule(schedule *models.AppSchedule) (*Schedule, error) {
+	if schedule == nil || len(schedule.Windows) == 0 {
+		return nil, nil
+	}
+
+	location := time.Local
+	if schedule.Timezone != "" {
+		var err error
+
+		location, err = time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %s", schedule.Timezone)
+		}
+	}
+
+	s := &Schedule{location: location}
+
+	for _, w := range schedule.Windows {
+		window := scheduleWindow{days: make(map[time.Weekday]bool)}
+
+		for _, d := range w.Days {
+			day, ok := scheduleDays[strings.ToLower(d)]
+			if !ok {
+				return nil, fmt.Errorf("invalid schedule day %s", d)
+			}
+
+			window.days[day] = true
+		}
+
+		// Every day when none is given.
+		if len(window.days) == 0 {
+			for _, day := range scheduleDays {
+				window.days[day] = true
+			}
+		}
+
+		var err error
+
+		window.start, err = parseScheduleTime(w.Start)
+		if err != nil {
+			return nil, err
+		}
+
+		window.end, err = parseScheduleTime(w.End)
+		if err != nil {
+			return nil, err
+		}
+
+		s.windows = append(s.windows, window)
+	}
+
+	return s, nil
+}
+
+func ValidateAppSchedule(schedule *models.AppSchedule) error {
+	_, err := ParseAppSchedule(schedule)
+
+	return err
+}
+
+// IsOpen reports whether t falls in one of the windows. A window ending before
+// it starts runs past midnight, its days are the days it starts on.
+func (s *Schedule) IsOpen(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	t = t.In(s.location)
+
+	minutes := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	for _, w := range s.windows {
+		switch {
+		case w.start < w.end:
+			if w.days[today] && minutes >= w.start && minutes < w.end {
+				return true
+			}
+		case w.start == w.end:
+			if w.days[today] {
+				return true
+			}
+		default:
+			if (w.days[today] && minutes >= w.start) || (w.days[yesterday] && minutes < w.end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
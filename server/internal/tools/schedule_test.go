@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"testing"
+	"time"
+
+	"github.com/middlewarr/server/internal/models"
+)
+
+func TestParseAppSchedule(t *testing.T) {
+	tests := []struct {
+		name  string
+		days  []string
+		start string
+		end   string
+		valid bool
+	}{
+		{"abbreviations", []string{"mon", "Tue", "WED"}, "08:00", "18:00", true},
+		{"full names", []string{"Thursday", "friday", "SATURDAY", "sunday"}, "08:00", "18:00", true},
+		{"every day", nil, "00:00", "24:00", true},
+		{"single digit hour", nil, "8:05", "18:00", true},
+		{"junk after an abbreviation", []string{"monxyz"}, "08:00", "18:00", false},
+		{"partial name", []string{"tues"}, "08:00", "18:00", false},
+		{"too short", []string{"mo"}, "08:00", "18:00", false},
+		{"empty day", []string{""}, "08:00", "18:00", false},
+		{"kelvin sign", []string{"K"}, "08:00", "18:00", false},
+		{"multibyte letters", []string{"KKK"}, "08:00", "18:00", false},
+		{"missing minutes", nil, "08", "18:00", false},
+		{"trailing junk", nil, "08:00xyz", "18:00", false},
+		{"sign", nil, "+8:00", "18:00", false},
+		{"hour out of range", nil, "25:00", "18:00", false},
+		{"minute out of range", nil, "08:60", "18:00", false},
+		{"past midnight", nil, "08:00", "24:01", false},
+		{"empty time", nil, "", "18:00", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAppSchedule(&models.AppSchedule{
+				Timezone: "UTC",
+				Windows:  []models.AppScheduleWindow{{Days: tt.days, Start: tt.start, End: tt.end}},
+			})
+
+			if (err == nil) != tt.valid {
+				t.Errorf("error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestScheduleIsOpen(t *testing.T) {
+	schedule, err := ParseAppSchedule(&models.AppSchedule{
+		Timezone: "Europe/Paris",
+		Windows: []models.AppScheduleWindow{
+			{Days: []string{"monday"}, Start: "09:00", End: "17:00"},
+			{Days: []string{"fri"}, Start: "22:00", End: "02:00"},
+			{Days: []string{"sun"}, Start: "00:00", End: "00:00"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paris, _ := time.LoadLocation("Europe/Paris")
+
+	tests := []struct {
+		name string
+		at   time.Time
+		open bool
+	}{
+		{"monday morning", time.Date(2026, 10, 12, 9, 0, 0, 0, paris), true},
+		{"monday evening", time.Date(2026, 10, 12, 17, 0, 0, 0, paris), false},
+		{"monday in another time zone", time.Date(2026, 10, 12, 7, 30, 0, 0, time.UTC), true},
+		{"tuesday", time.Date(2026, 10, 13, 10, 0, 0, 0, paris), false},
+		{"friday night", time.Date(2026, 10, 16, 23, 0, 0, 0, paris), true},
+		{"saturday after midnight", time.Date(2026, 10, 17, 1, 59, 0, 0, paris), true},
+		{"saturday morning", time.Date(2026, 10, 17, 2, 0, 0, 0, paris), false},
+		{"sunday all day", time.Date(2026, 10, 18, 23, 59, 0, 0, paris), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schedule.IsOpen(tt.at); got != tt.open {
+				t.Errorf("IsOpen(%s) = %v, want %v", tt.at, got, tt.open)
+			}
+		})
+	}
+
+	var none *Schedule
+	if !none.IsOpen(time.Now()) {
+		t.Error("an app without schedule is closed")
+	}
+}
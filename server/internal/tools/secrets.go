@@ -0,0 +1,173 @@
+package tools
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	masterKeyEnv         string = "MIDDLEWARR_MASTER_KEY"
+	previousMasterKeyEnv string = "MIDDLEWARR_PREVIOUS_MASTER_KEY"
+	masterKeyFile        string = "master.key"
+
+	secretPrefix string = "enc:v1:"
+)
+
+var onceMasterKeys sync.Once
+
+// The current master key encrypts, every known key decrypts.
+var masterKeys struct {
+	current cipher.AEAD
+	all     []cipher.AEAD
+	err     error
+}
+
+func newMasterKey(material string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(material))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// readMasterKeyFile returns the key file of the data directory, it is
+// generated on first use when no master key is provided.
+func readMasterKeyFile(create bool) (string, error) {
+	path := GetDataSubPath(masterKeyFile)
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) || !create {
+		return "", err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	material := hex.EncodeToString(key)
+
+	if err := os.WriteFile(path, []byte(material+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	l := GetLogger()
+
+	l.Warn().
+		Str("path", path).
+		Msg("Master key generated, keep a backup of it")
+
+	return material, nil
+}
+
+func loadMasterKeys() {
+	var materials []string
+
+	if material := os.Getenv(masterKeyEnv); material != "" {
+		materials = append(materials, material)
+
+		// The key file remains readable after switching to the environment.
+		if material, err := readMasterKeyFile(false); err == nil && material != "" {
+			materials = append(materials, material)
+		}
+	} else {
+		material, err := readMasterKeyFile(true)
+		if err != nil {
+			masterKeys.err = err
+			return
+		}
+
+		materials = append(materials, material)
+	}
+
+	if material := os.Getenv(previousMasterKeyEnv); material != "" {
+		materials = append(materials, material)
+	}
+
+	for _, material := range materials {
+		aead, err := newMasterKey(material)
+		if err != nil {
+			masterKeys.err = err
+			return
+		}
+
+		masterKeys.all = append(masterKeys.all, aead)
+	}
+
+	masterKeys.current = masterKeys.all[0]
+}
+
+func getMasterKeys() error {
+	onceMasterKeys.Do(loadMasterKeys)
+
+	return masterKeys.err
+}
+
+func IsEncryptedSecret(secret string) bool {
+	return strings.HasPrefix(secret, secretPrefix)
+}
+
+// EncryptSecret seals a secret with the current master key.
+func EncryptSecret(plaintext string) (string, error) {
+	if err := getMasterKeys(); err != nil {
+		return "", err
+	}
+
+	aead := masterKeys.current
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret opens a secret with any known master key, values stored
+// before encryption was introduced are returned as is.
+func DecryptSecret(secret string) (string, error) {
+	if !IsEncryptedSecret(secret) {
+		return secret, nil
+	}
+
+	if err := getMasterKeys(); err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(secret[len(secretPrefix):])
+	if err != nil {
+		return "", err
+	}
+
+	for _, aead := range masterKeys.all {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+		plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err == nil {
+			return string(plaintext), nil
+		}
+	}
+
+	return "", errors.New("cannot decrypt secret, unknown master key")
+}
@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func resetMasterKeys() {
+	onceMasterKeys = sync.Once{}
+	masterKeys.current, masterKeys.all, masterKeys.err = nil, nil, nil
+}
+
+// useMasterKeys reloads the master keys from the environment of the test.
+func useMasterKeys(t *testing.T, current string, previous string) {
+	t.Helper()
+
+	t.Setenv(masterKeyEnv, current)
+	t.Setenv(previousMasterKeyEnv, previous)
+
+	resetMasterKeys()
+	t.Cleanup(resetMasterKeys)
+
+	if err := getMasterKeys(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useMasterKeys(t, "current", "")
+
+	secret, err := EncryptSecret("service0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsEncryptedSecret(secret) || strings.Contains(secret, "service0123456789") {
+		t.Errorf("EncryptSecret() = %q", secret)
+	}
+
+	again, err := EncryptSecret("service0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again == secret {
+		t.Error("EncryptSecret() reused a nonce")
+	}
+
+	for _, s := range []string{secret, again} {
+		if plaintext, err := DecryptSecret(s); err != nil || plaintext != "service0123456789" {
+			t.Errorf("DecryptSecret(%q) = %q, %v", s, plaintext, err)
+		}
+	}
+
+	// Values stored before the encryption are used as is.
+	if plaintext, err := DecryptSecret("service0123456789"); err != nil || plaintext != "service0123456789" {
+		t.Errorf("DecryptSecret(plaintext) = %q, %v", plaintext, err)
+	}
+}
+
+func TestDecryptSecretErrors(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useMasterKeys(t, "current", "")
+
+	secret, err := EncryptSecret("service0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"not base64", secretPrefix + "!!!"},
+		{"too short", secretPrefix + "AAAA"},
+		{"tampered", secret[:len(secret)-4] + "AAAA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plaintext, err := DecryptSecret(tt.secret); err == nil {
+				t.Errorf("DecryptSecret(%q) = %q, want an error", tt.secret, plaintext)
+			}
+		})
+	}
+
+	useMasterKeys(t, "other", "")
+
+	if plaintext, err := DecryptSecret(secret); err == nil {
+		t.Errorf("DecryptSecret() with the wrong key = %q, want an error", plaintext)
+	}
+}
+
+func TestMasterKeyRotation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useMasterKeys(t, "old", "")
+
+	old, err := EncryptSecret("service0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous key still decrypts, the new one encrypts.
+	useMasterKeys(t, "new", "old")
+
+	if plaintext, err := DecryptSecret(old); err != nil || plaintext != "service0123456789" {
+		t.Fatalf("DecryptSecret() with the previous key = %q, %v", plaintext, err)
+	}
+
+	secret, err := EncryptSecret("service0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useMasterKeys(t, "new", "")
+
+	if plaintext, err := DecryptSecret(secret); err != nil || plaintext != "service0123456789" {
+		t.Errorf("DecryptSecret() with the new key = %q, %v", plaintext, err)
+	}
+
+	if _, err := DecryptSecret(old); err == nil {
+		t.Error("DecryptSecret() succeeded once the previous key is gone")
+	}
+}
+
+func TestMasterKeyFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a key in the environment, one is generated in the data directory.
+	useMasterKeys(t, "", "")
+
+	secret, err := EncryptSecret("service0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(GetDataSubPath(masterKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	// The key file still decrypts after moving to the environment.
+	useMasterKeys(t, "environment", "")
+
+	if plaintext, err := DecryptSecret(secret); err != nil || plaintext != "service0123456789" {
+		t.Errorf("DecryptSecret() with the key file = %q, %v", plaintext, err)
+	}
+}